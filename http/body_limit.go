@@ -0,0 +1,167 @@
+package http
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BodyLimitModel bounds the request bodies DeliveryHandler reads. MaxBytes
+// and AllowedContentTypes apply to every route unless Routes, keyed by
+// "METHOD /path" or "/path" as registered in gin, overrides them. The body
+// of a Stream route is left unread for the handler, and only capped by the
+// MaxBytes of the route itself. Logged bodies are cut at LogMaxBytes. Zero
+// values mean no limit.
+type BodyLimitModel struct {
+	MaxBytes            int64
+	AllowedContentTypes []string
+	LogMaxBytes         int
+	Routes              map[string]BodyLimitRoute
+	Snap                bool
+	ServiceCode         string
+	HttpCode            int
+	Code                string
+	ContentTypeHttpCode int
+	ContentTypeCode     string
+}
+
+type BodyLimitRoute struct {
+	MaxBytes            int64
+	AllowedContentTypes []string
+	Stream              bool
+}
+
+var (
+	ErrBodyTooLarge       = errors.New("request body too large")
+	ErrUnsupportedContent = errors.New("unsupported content type")
+)
+
+func bodyLimitRoute(c *gin.Context) BodyLimitRoute {
+	model := OptConfig.BodyLimit
+
+	route, found := model.Routes[c.Request.Method+" "+c.FullPath()]
+	if !found {
+		route, found = model.Routes[c.FullPath()]
+	}
+
+	if !found {
+		return BodyLimitRoute{
+			MaxBytes:            model.MaxBytes,
+			AllowedContentTypes: model.AllowedContentTypes,
+		}
+	}
+
+	if route.MaxBytes == 0 && !route.Stream {
+		route.MaxBytes = model.MaxBytes
+	}
+
+	if route.AllowedContentTypes == nil {
+		route.AllowedContentTypes = model.AllowedContentTypes
+	}
+
+	return route
+}
+
+// checkBody rejects a request whose content type is not allowed or whose
+// declared length is over the limit, and caps the body for the reads that
+// follow.
+func (r BodyLimitRoute) checkBody(c *gin.Context) error {
+	if c.Request.ContentLength == 0 {
+		return nil
+	}
+
+	if !r.allowsContentType(c.GetHeader("Content-Type")) {
+		return ErrUnsupportedContent
+	}
+
+	if r.MaxBytes <= 0 {
+		return nil
+	}
+
+	if c.Request.ContentLength > r.MaxBytes {
+		return ErrBodyTooLarge
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, r.MaxBytes)
+	return nil
+}
+
+func (r BodyLimitRoute) allowsContentType(contentType string) bool {
+	if len(r.AllowedContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range r.AllowedContentTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType || allowed == "*/*" {
+			return true
+		}
+
+		if prefix, found := strings.CutSuffix(allowed, "/*"); found && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func abortBodyLimit(c *gin.Context, responseId int64, err error) {
+	model := OptConfig.BodyLimit
+
+	optData := OptSetR{
+		HttpCode:    model.HttpCode,
+		Code:        model.Code,
+		ServiceCode: model.ServiceCode,
+	}
+
+	if optData.HttpCode == 0 {
+		optData.HttpCode = http.StatusRequestEntityTooLarge
+	}
+
+	if errors.Is(err, ErrUnsupportedContent) {
+		optData.HttpCode = model.ContentTypeHttpCode
+		optData.Code = model.ContentTypeCode
+
+		if optData.HttpCode == 0 {
+			optData.HttpCode = http.StatusUnsupportedMediaType
+		}
+	}
+
+	if model.Snap && optData.Code == "" {
+		snapCase := snapCaseForHttpCode(optData.HttpCode)
+		optData.SnapCase = &snapCase
+	}
+
+	response := InitResponse(responseId, getLanguage(c))
+	response.SetErrorR(&err, Tracer(), optData)
+	abortWithResponse(c, response, model.Snap)
+}
+
+func isBodyTooLarge(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
+
+// truncateLogBody cuts body at OptConfig.BodyLimit.LogMaxBytes for the logs.
+func truncateLogBody(body string) string {
+	limit := OptConfig.BodyLimit.LogMaxBytes
+	if limit <= 0 || len(body) <= limit {
+		return body
+	}
+
+	for limit > 0 && !utf8.RuneStart(body[limit]) {
+		limit--
+	}
+
+	return body[:limit] + "...(truncated " + strconv.Itoa(len(body)-limit) + " bytes)"
+}
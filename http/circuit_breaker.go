@@ -0,0 +1,303 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/h4lim/og-kds/infra"
+	"go.uber.org/zap"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+var (
+	circuitBreakers   = make(map[string]*circuitBreaker)
+	circuitModels     = make(map[string]CircuitBreakerModel)
+	circuitBreakersMu sync.Mutex
+)
+
+// CircuitBreakerModel opens the circuit when ConsecutiveFailures failures
+// happen in a row, or when at least MinRequests calls were made in the
+// current Window and FailureRate of them failed; MinRequests is 10 by
+// default so a single failure cannot trip the rate. After CoolDown up to
+// HalfOpenRequests probes are let through to decide whether to close it.
+type CircuitBreakerModel struct {
+	Enabled             bool
+	ConsecutiveFailures int
+	FailureRate         float64
+	MinRequests         int
+	Window              time.Duration
+	CoolDown            time.Duration
+	HalfOpenRequests    int
+}
+
+type CircuitBreakerState struct {
+	Name                string
+	State               string
+	Requests            int
+	Failures            int
+	ConsecutiveFailures int
+	Since               time.Time
+}
+
+type circuitBreaker struct {
+	name  string
+	model CircuitBreakerModel
+
+	mu                  sync.Mutex
+	state               string
+	since               time.Time
+	windowStart         time.Time
+	requests            int
+	failures            int
+	consecutiveFailures int
+	probes              int
+	generation          int
+}
+
+// circuitPermit is the pass of one call through the breaker. It has to be
+// recorded, or released when the call never got an outcome, so a half-open
+// probe is not held forever.
+type circuitPermit struct {
+	breaker    *circuitBreaker
+	generation int
+	probe      bool
+	done       bool
+}
+
+// RegisterCircuitBreaker overrides OptConfigModel.CircuitBreaker for the
+// named client or host.
+func RegisterCircuitBreaker(name string, model CircuitBreakerModel) {
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+
+	circuitModels[name] = model
+	delete(circuitBreakers, name)
+}
+
+func GetCircuitBreakerStates() map[string]CircuitBreakerState {
+	circuitBreakersMu.Lock()
+	breakers := make([]*circuitBreaker, 0, len(circuitBreakers))
+	for _, breaker := range circuitBreakers {
+		breakers = append(breakers, breaker)
+	}
+	circuitBreakersMu.Unlock()
+
+	states := make(map[string]CircuitBreakerState, len(breakers))
+	for _, breaker := range breakers {
+		states[breaker.name] = breaker.snapshot()
+	}
+
+	return states
+}
+
+// circuitBreakerFor returns the breaker of the request, keyed by
+// ClientRequest.CircuitName or else the URL host, or nil when disabled.
+func circuitBreakerFor(request ClientRequest) *circuitBreaker {
+	name := request.CircuitName
+	if name == "" {
+		parsed, err := url.Parse(request.URL)
+		if err != nil {
+			return nil
+		}
+		name = parsed.Host
+	}
+
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+
+	if breaker, ok := circuitBreakers[name]; ok {
+		return breaker
+	}
+
+	model, ok := circuitModels[name]
+	if !ok {
+		model = OptConfig.CircuitBreaker
+	}
+
+	if !model.Enabled {
+		return nil
+	}
+
+	if model.ConsecutiveFailures <= 0 && model.FailureRate <= 0 {
+		model.ConsecutiveFailures = 5
+	}
+
+	if model.FailureRate > 0 && model.MinRequests <= 0 {
+		model.MinRequests = 10
+	}
+
+	if model.Window <= 0 {
+		model.Window = time.Minute
+	}
+
+	if model.CoolDown <= 0 {
+		model.CoolDown = 30 * time.Second
+	}
+
+	if model.HalfOpenRequests <= 0 {
+		model.HalfOpenRequests = 1
+	}
+
+	now := time.Now()
+	breaker := &circuitBreaker{
+		name:        name,
+		model:       model,
+		state:       CircuitClosed,
+		since:       now,
+		windowStart: now,
+	}
+	circuitBreakers[name] = breaker
+
+	return breaker
+}
+
+func (b *circuitBreaker) allow() (*circuitPermit, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case CircuitOpen:
+		if now.Sub(b.since) < b.model.CoolDown {
+			return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, b.name)
+		}
+		b.transition(CircuitHalfOpen, now)
+	case CircuitClosed:
+		if now.Sub(b.windowStart) >= b.model.Window {
+			b.windowStart = now
+			b.requests = 0
+			b.failures = 0
+		}
+	}
+
+	permit := &circuitPermit{breaker: b, generation: b.generation}
+	if b.state == CircuitHalfOpen {
+		if b.probes >= b.model.HalfOpenRequests {
+			return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, b.name)
+		}
+		b.probes++
+		permit.probe = true
+	}
+
+	return permit, nil
+}
+
+func (p *circuitPermit) record(success bool) {
+	if p == nil || p.done {
+		return
+	}
+
+	p.done = true
+	p.breaker.record(p.generation, success)
+}
+
+// release gives back a probe that got no outcome, e.g. when signing failed
+// or the caller cancelled. It is a no-op after record.
+func (p *circuitPermit) release() {
+	if p == nil || p.done {
+		return
+	}
+
+	p.done = true
+	if !p.probe {
+		return
+	}
+
+	b := p.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.generation == p.generation && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// record counts the outcome of a call admitted in generation. An outcome
+// from before the last transition is dropped, so a slow call admitted while
+// closed cannot close the circuit while the real probe is in flight.
+func (b *circuitBreaker) record(generation int, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	now := time.Now()
+	b.requests++
+	if success {
+		b.consecutiveFailures = 0
+	} else {
+		b.failures++
+		b.consecutiveFailures++
+	}
+
+	switch b.state {
+	case CircuitHalfOpen:
+		if !success {
+			b.transition(CircuitOpen, now)
+		} else if b.requests >= b.model.HalfOpenRequests {
+			b.transition(CircuitClosed, now)
+		}
+	case CircuitClosed:
+		if !success && b.tripped() {
+			b.transition(CircuitOpen, now)
+		}
+	}
+}
+
+func (b *circuitBreaker) tripped() bool {
+	if b.model.ConsecutiveFailures > 0 && b.consecutiveFailures >= b.model.ConsecutiveFailures {
+		return true
+	}
+
+	return b.model.FailureRate > 0 && b.requests >= b.model.MinRequests &&
+		float64(b.failures)/float64(b.requests) >= b.model.FailureRate
+}
+
+// transition must be called with b.mu held.
+func (b *circuitBreaker) transition(state string, now time.Time) {
+	if infra.ZapLog != nil {
+		infra.ZapLog.Warn("circuit breaker "+b.name,
+			zap.String("from", b.state),
+			zap.String("to", state),
+			zap.Int("requests", b.requests),
+			zap.Int("failures", b.failures),
+			zap.Int("consecutive-failures", b.consecutiveFailures))
+	}
+
+	b.state = state
+	b.generation++
+	b.since = now
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+	b.probes = 0
+	if state == CircuitClosed {
+		b.consecutiveFailures = 0
+	}
+}
+
+func (b *circuitBreaker) snapshot() CircuitBreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return CircuitBreakerState{
+		Name:                b.name,
+		State:               b.state,
+		Requests:            b.requests,
+		Failures:            b.failures,
+		ConsecutiveFailures: b.consecutiveFailures,
+		Since:               b.since,
+	}
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,6 +25,12 @@ type ClientRequest struct {
 	Password         *string
 	AdditionalTracer *[]string
 	ResponseId       int64
+	Retry            *RetryPolicy
+	CircuitName      string
+	Context          context.Context `json:"-"`
+	Timeout          time.Duration
+	Transport        Transport      `json:"-"`
+	Snap             *SnapSignModel `json:"-"`
 }
 
 type ClientContext struct {
@@ -35,6 +42,9 @@ type ClientContext struct {
 type logData struct {
 	RequestData  ClientRequest   `json:"request_data"`
 	ResponseData *party.Response `json:"response_data"`
+	Attempt      int             `json:"attempt,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	Timeout      bool            `json:"timeout,omitempty"`
 }
 
 type IClient interface {
@@ -84,10 +94,62 @@ func (c ClientContext) UnmarshalJson(jsonData any) ClientContext {
 	return c
 }
 
+// Hit sends the request, retrying it under ClientRequest.Retry. Every
+// attempt is logged as its own step and bounded by ClientRequest.Timeout.
+// Without ClientRequest.Context the context of the inbound request traced
+// by DeliveryHandler is used, so a cancelled request cancels its calls.
 func (c ClientContext) Hit() ClientContext {
 
-	clientParty := party.NewClientParty(c.ClientRequest.HttpMethod, c.ClientRequest.URL).
-		SetHeader(c.ClientRequest.Header["Content-Type"], c.ClientRequest.Header)
+	ctx := c.ClientRequest.requestContext()
+	c.ClientRequest = c.ClientRequest.withSnapExternalId()
+
+	if c.ClientRequest.Retry == nil || c.ClientRequest.Retry.MaxAttempts <= 1 {
+		return c.hit(ctx, 0)
+	}
+
+	policy := c.ClientRequest.Retry.withDefaults()
+	for attempt := 1; ; attempt++ {
+		c = c.hit(ctx, attempt)
+
+		delay, retry := policy.shouldRetry(attempt, c)
+		if !retry {
+			return c
+		}
+
+		select {
+		case <-ctx.Done():
+			return c
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c ClientContext) hit(ctx context.Context, attempt int) ClientContext {
+
+	c.PartyResponse = nil
+	c.Error = nil
+
+	var permit *circuitPermit
+	if breaker := circuitBreakerFor(c.ClientRequest); breaker != nil {
+		allowed, err := breaker.allow()
+		if err != nil {
+			c.Error = err
+			c.logNotSent(attempt)
+			return c
+		}
+		permit = allowed
+	}
+	defer permit.release()
+
+	if c.ClientRequest.Snap != nil {
+		signed, err := c.ClientRequest.signSnap()
+		if err != nil {
+			c.Error = err
+			c.logNotSent(attempt)
+			return c
+		}
+		c.ClientRequest = signed
+	}
 
 	var zapFields []zapcore.Field
 
@@ -95,23 +157,21 @@ func (c ClientContext) Hit() ClientContext {
 		zapFields = append(zapFields, zap.String("additional-tracer", strings.Join(*c.ClientRequest.AdditionalTracer, " ")))
 	}
 
-	zapFields = append(zapFields, zap.String("url", c.ClientRequest.URL))
+	if attempt > 0 {
+		zapFields = append(zapFields, zap.Int("attempt", attempt))
+	}
+
+	zapFields = append(zapFields, zap.String("url", MaskText(c.ClientRequest.URL)))
 	zapFields = append(zapFields, zap.String("http-methode", c.ClientRequest.HttpMethod))
-	zapFields = append(zapFields, zap.String("header", fmt.Sprintf("%v", &c.ClientRequest.Header)))
+	zapFields = append(zapFields, zap.String("header", fmt.Sprintf("%v", MaskHeaderMap(c.ClientRequest.Header))))
 
 	if c.ClientRequest.RequestBody != nil {
-		clientParty.SetRequestBodyStr(*c.ClientRequest.RequestBody)
-		zapFields = append(zapFields, zap.String("request-body", *c.ClientRequest.RequestBody))
+		zapFields = append(zapFields, zap.String("request-body", MaskBody(*c.ClientRequest.RequestBody)))
 	}
 
 	if c.ClientRequest.QueryParam != nil {
-		clientParty.SetQueryParam(*c.ClientRequest.QueryParam)
 		zapFields = append(zapFields, zap.String("query-param",
-			fmt.Sprintf("%v", *c.ClientRequest.QueryParam)))
-	}
-
-	if c.ClientRequest.Username != nil && c.ClientRequest.Password != nil {
-		clientParty.SetBaseAuth(*c.ClientRequest.Username, *c.ClientRequest.Password)
+			fmt.Sprintf("%v", MaskHeaderMap(*c.ClientRequest.QueryParam))))
 	}
 
 	zapFields = append(zapFields, zap.String("step", GetNextStep(c.ClientRequest.ResponseId)))
@@ -119,20 +179,31 @@ func (c ClientContext) Hit() ClientContext {
 	duration := GetDuration(c.ClientRequest.ResponseId) + " ms"
 	zapFields = append(zapFields, zap.String("duration", duration))
 
-	totalDuration := time.Now().UnixNano() - c.ClientRequest.ResponseId
-	ms := float64(totalDuration) / float64(time.Millisecond)
-	zapFields = append(zapFields, zap.String("total-duration", fmt.Sprintf("%v", ms)+" ms"))
+	zapFields = append(zapFields, zap.String("total-duration", GetTotalDuration(c.ClientRequest.ResponseId)+" ms"))
+
+	clientResponse, err := c.ClientRequest.roundTrip(ctx)
+	if !errors.Is(err, ErrClientCanceled) {
+		permit.record(err == nil && clientResponse.HttpCode < 500)
+	}
 
-	clientResponse, err := clientParty.HitClient()
 	if err != nil {
 		zapFields = append(zapFields, zap.String("error",
-			fmt.Sprintf("%v", *err)))
+			fmt.Sprintf("%v", err)))
+
+		if errors.Is(err, ErrClientTimeout) {
+			zapFields = append(zapFields, zap.Bool("timeout", true))
+		}
 
 		if infra.ZapLog != nil {
 			infra.ZapLog.Warn(strconv.FormatInt(c.ClientRequest.ResponseId, 10), zapFields...)
 		}
 
-		c.Error = *err
+		c.Error = err
+
+		if OptConfig.SqlLogs {
+			c.logSql(duration, attempt)
+		}
+
 		return c
 	}
 
@@ -140,10 +211,10 @@ func (c ClientContext) Hit() ClientContext {
 		clientResponse.HttpCode))
 
 	zapFields = append(zapFields, zap.String("client-response-body",
-		clientResponse.ResponseBody))
+		MaskBody(clientResponse.ResponseBody)))
 
 	zapFields = append(zapFields, zap.String("client-response-header",
-		fmt.Sprintf("%v", clientResponse.ResponseHeader)))
+		fmt.Sprintf("%v", maskHeaders(clientResponse.ResponseHeader))))
 
 	if infra.ZapLog != nil {
 		infra.ZapLog.Debug(strconv.FormatInt(c.ClientRequest.ResponseId, 10), zapFields...)
@@ -152,28 +223,88 @@ func (c ClientContext) Hit() ClientContext {
 	c.PartyResponse = clientResponse
 
 	if OptConfig.SqlLogs {
-		c.logSql(duration)
+		c.logSql(duration, attempt)
 	}
 
 	return c
 }
 
-func (c ClientContext) logSql(duration string) {
+// logNotSent logs the step of an attempt rejected before reaching the
+// transport, e.g. by an open circuit.
+func (c ClientContext) logNotSent(attempt int) {
+
+	step := GetNextStep(c.ClientRequest.ResponseId)
+	duration := GetDuration(c.ClientRequest.ResponseId) + " ms"
+
+	if infra.ZapLog != nil {
+		zapFields := []zapcore.Field{}
+		zapFields = append(zapFields, zap.String("step", step))
+		zapFields = append(zapFields, zap.String("duration", duration))
+		zapFields = append(zapFields, zap.String("url", MaskText(c.ClientRequest.URL)))
+		zapFields = append(zapFields, zap.String("error", c.Error.Error()))
+		infra.ZapLog.Warn(strconv.FormatInt(c.ClientRequest.ResponseId, 10), zapFields...)
+	}
+
+	if OptConfig.SqlLogs {
+		c.logSql(duration, attempt)
+	}
+}
+
+func (c ClientContext) logSql(duration string, attempt int) {
 	logData := logData{
-		RequestData:  c.ClientRequest,
+		RequestData:  c.ClientRequest.masked(),
 		ResponseData: c.PartyResponse,
+		Attempt:      attempt,
+	}
+
+	if c.Error != nil {
+		logData.Error = c.Error.Error()
+		logData.Timeout = errors.Is(c.Error, ErrClientTimeout)
+	}
+
+	if masking != nil && c.PartyResponse != nil {
+		maskedResponse := *c.PartyResponse
+		maskedResponse.ResponseBody = MaskBody(maskedResponse.ResponseBody)
+		maskedResponse.ResponseHeader = maskHeaders(maskedResponse.ResponseHeader)
+		logData.ResponseData = &maskedResponse
 	}
 
 	data := sqlLog{
 		ResponseID:   strconv.FormatInt(c.ClientRequest.ResponseId, 10),
 		Step:         GetStepInt(c.ClientRequest.ResponseId),
-		FunctionName: c.ClientRequest.URL,
+		FunctionName: MaskText(c.ClientRequest.URL),
 		Data:         jsonMarshal(logData),
 		Duration:     duration,
-		RequestID:    RequestId[c.ClientRequest.ResponseId],
+		RequestID:    GetRequestId(c.ClientRequest.ResponseId),
+	}
+
+	saveSqlLog(data)
+}
+
+// masked returns a copy of the request that is safe to log. The basic auth
+// password is never logged.
+func (r ClientRequest) masked() ClientRequest {
+	if r.Password != nil {
+		password := "****"
+		r.Password = &password
+	}
+
+	if masking == nil {
+		return r
+	}
+
+	r.URL = MaskText(r.URL)
+	r.Header = MaskHeaderMap(r.Header)
+
+	if r.RequestBody != nil {
+		requestBody := MaskBody(*r.RequestBody)
+		r.RequestBody = &requestBody
+	}
+
+	if r.QueryParam != nil {
+		queryParam := MaskHeaderMap(*r.QueryParam)
+		r.QueryParam = &queryParam
 	}
 
-	go func() {
-		_ = infra.GormDB.Debug().Create(&data)
-	}()
+	return r
 }
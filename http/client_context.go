@@ -0,0 +1,80 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	party "github.com/h4lim/client-party"
+)
+
+var (
+	ErrClientTimeout  = errors.New("client request timed out")
+	ErrClientCanceled = errors.New("client request canceled")
+
+	// ErrClientAbandoned marks a call that was given up on while the request
+	// may still reach the partner. Such a call is never retried.
+	ErrClientAbandoned = errors.New("client request abandoned in flight")
+)
+
+// requestContext resolves the context of the outbound call. A *gin.Context
+// is unwrapped to its request context, because gin only reports the
+// cancellation of the inbound request there.
+func (r ClientRequest) requestContext() context.Context {
+	ctx := r.Context
+	if ctx == nil {
+		if trace, ok := LoadTrace(r.ResponseId); ok {
+			ctx = trace.Context()
+		}
+	}
+
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return ctx
+}
+
+// call runs hitClient under ctx. client-party does not take a context, so
+// on cancellation the request keeps running: the call is abandoned, its
+// result dropped and the error wraps ErrClientAbandoned so it is not sent
+// a second time by a retry.
+func (r ClientRequest) call(ctx context.Context, hitClient func() (*party.Response, *error)) (*party.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(err, r)
+	}
+
+	type result struct {
+		response *party.Response
+		err      *error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		response, err := hitClient()
+		done <- result{response: response, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return nil, *res.err
+		}
+		return res.response, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w, %w", contextError(ctx.Err(), r), ErrClientAbandoned)
+	}
+}
+
+func contextError(err error, r ClientRequest) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s %s: %w", ErrClientTimeout, r.HttpMethod, r.URL, err)
+	}
+
+	return fmt.Errorf("%w: %s %s: %w", ErrClientCanceled, r.HttpMethod, r.URL, err)
+}
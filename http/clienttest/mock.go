@@ -0,0 +1,163 @@
+package clienttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	party "github.com/h4lim/client-party"
+	"github.com/h4lim/og-kds/http"
+)
+
+// Mock is an http.Transport answering requests from expectations instead
+// of the network.
+type Mock struct {
+	mu           sync.Mutex
+	expectations []*Expectation
+	unmatched    []string
+}
+
+type Expectation struct {
+	method   string
+	url      string
+	body     *string
+	response *party.Response
+	err      error
+	times    int
+	calls    int
+}
+
+func NewMock() *Mock {
+	return &Mock{}
+}
+
+// Expect registers an expectation for method and url. The url is matched
+// exactly, or as a prefix when it ends with "*".
+func (m *Mock) Expect(method string, url string) *Expectation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expectation := &Expectation{
+		method: strings.ToUpper(method),
+		url:    url,
+		times:  1,
+	}
+	m.expectations = append(m.expectations, expectation)
+
+	return expectation
+}
+
+func (e *Expectation) WithBody(body string) *Expectation {
+	e.body = &body
+	return e
+}
+
+func (e *Expectation) Respond(response *party.Response) *Expectation {
+	e.response = response
+	return e
+}
+
+func (e *Expectation) RespondJson(httpCode int, body string) *Expectation {
+	return e.Respond(&party.Response{
+		HttpCode:     httpCode,
+		ResponseBody: body,
+	})
+}
+
+func (e *Expectation) RespondError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Times sets how often the expectation may match; zero or less means any
+// number of times.
+func (e *Expectation) Times(times int) *Expectation {
+	e.times = times
+	return e
+}
+
+// RoundTrip implements http.Transport.
+func (m *Mock) RoundTrip(ctx context.Context, request http.ClientRequest) (*party.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, expectation := range m.expectations {
+		if expectation.times > 0 && expectation.calls >= expectation.times {
+			continue
+		}
+
+		if !expectation.matches(request) {
+			continue
+		}
+
+		expectation.calls++
+		if expectation.err != nil {
+			return nil, expectation.err
+		}
+
+		if expectation.response == nil {
+			return nil, errors.New("clienttest: expectation " + expectation.method + " " + expectation.url +
+				" has neither Respond nor RespondError")
+		}
+
+		response := *expectation.response
+		return &response, nil
+	}
+
+	description := describe(request)
+	m.unmatched = append(m.unmatched, description)
+
+	return nil, errors.New("clienttest: unexpected request " + description)
+}
+
+// AssertExpectations fails t for every request that matched nothing and
+// every expectation that was not used as often as required.
+func (m *Mock) AssertExpectations(t testing.TB) {
+	t.Helper()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, description := range m.unmatched {
+		t.Errorf("clienttest: unmatched request %s", description)
+	}
+
+	for _, expectation := range m.expectations {
+		if expectation.calls == 0 || (expectation.times > 0 && expectation.calls < expectation.times) {
+			t.Errorf("clienttest: expectation %s %s used %d of %d times",
+				expectation.method, expectation.url, expectation.calls, expectation.times)
+		}
+	}
+}
+
+func (e *Expectation) matches(request http.ClientRequest) bool {
+	if e.method != strings.ToUpper(request.HttpMethod) {
+		return false
+	}
+
+	if strings.HasSuffix(e.url, "*") {
+		if !strings.HasPrefix(request.URL, strings.TrimSuffix(e.url, "*")) {
+			return false
+		}
+	} else if e.url != request.URL {
+		return false
+	}
+
+	if e.body != nil {
+		return request.RequestBody != nil && sameBody(*e.body, *request.RequestBody)
+	}
+
+	return true
+}
+
+func describe(request http.ClientRequest) string {
+	description := fmt.Sprintf("%s %s", strings.ToUpper(request.HttpMethod), request.URL)
+	if request.RequestBody != nil {
+		description += " " + *request.RequestBody
+	}
+
+	return description
+}
@@ -0,0 +1,121 @@
+package clienttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	party "github.com/h4lim/client-party"
+	"github.com/h4lim/og-kds/http"
+)
+
+// recordingT collects the failures AssertExpectations reports.
+type recordingT struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recordingT) Helper() {
+}
+
+func (r *recordingT) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func hit(transport http.Transport, method string, url string, body *string) (*party.Response, error) {
+	return transport.RoundTrip(context.Background(), http.ClientRequest{
+		HttpMethod:  method,
+		URL:         url,
+		RequestBody: body,
+	})
+}
+
+func TestMockMatchesMethodUrlAndBody(t *testing.T) {
+	mock := NewMock()
+	mock.Expect("post", "http://partner/pay").WithBody(`{"amount":1,"currency":"IDR"}`).RespondJson(200, `{"ok":true}`)
+	mock.Expect("GET", "http://partner/status/*").RespondJson(202, `{}`).Times(2)
+
+	body := `{"currency":"IDR", "amount":1}`
+	response, err := hit(mock, "POST", "http://partner/pay", &body)
+	if err != nil || response.ResponseBody != `{"ok":true}` {
+		t.Fatalf("unexpected response %v %v", response, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		response, err = hit(mock, "GET", fmt.Sprintf("http://partner/status/%d", i), nil)
+		if err != nil || response.HttpCode != 202 {
+			t.Fatalf("unexpected response %v %v", response, err)
+		}
+	}
+
+	mock.AssertExpectations(t)
+}
+
+func TestMockReportsUnmatchedAndUnused(t *testing.T) {
+	mock := NewMock()
+	mock.Expect("GET", "http://partner/a").RespondJson(200, `{}`)
+	mock.Expect("GET", "http://partner/b").RespondJson(200, `{}`).Times(2)
+
+	body := `{"a":1}`
+	if _, err := hit(mock, "POST", "http://partner/a", &body); err == nil {
+		t.Fatal("expected an unmatched request to fail")
+	}
+
+	if _, err := hit(mock, "GET", "http://partner/b", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &recordingT{}
+	mock.AssertExpectations(recorder)
+
+	if len(recorder.failures) != 3 {
+		t.Fatalf("expected 3 failures, got %q", recorder.failures)
+	}
+
+	if !strings.Contains(recorder.failures[0], `POST http://partner/a {"a":1}`) {
+		t.Fatalf("unexpected failure %q", recorder.failures[0])
+	}
+}
+
+func TestMockErrorsAndMissingResponse(t *testing.T) {
+	mock := NewMock()
+	errPartner := errors.New("connection reset")
+	mock.Expect("GET", "http://partner/down").RespondError(errPartner)
+	mock.Expect("GET", "http://partner/empty")
+
+	if _, err := hit(mock, "GET", "http://partner/down", nil); !errors.Is(err, errPartner) {
+		t.Fatalf("expected the configured error, got %v", err)
+	}
+
+	_, err := hit(mock, "GET", "http://partner/empty", nil)
+	if err == nil || !strings.Contains(err.Error(), "neither Respond nor RespondError") {
+		t.Fatalf("expected a missing response error, got %v", err)
+	}
+}
+
+func TestMockThroughClient(t *testing.T) {
+	mock := NewMock()
+	mock.Expect("GET", "http://partner/ok").RespondJson(200, `{"a":1}`)
+
+	responseId := http.NewResponseId()
+	defer http.ReleaseTrace(responseId)
+
+	var result struct {
+		A int `json:"a"`
+	}
+
+	client := http.NewClient(http.ClientRequest{
+		HttpMethod: "GET",
+		URL:        "http://partner/ok",
+		Transport:  mock,
+		ResponseId: responseId,
+	}).Hit().MustHttpOk200().UnmarshalJson(&result)
+
+	if _, err := client.GetPartyResponse(); err != nil || result.A != 1 {
+		t.Fatalf("unexpected result %v %v", result, err)
+	}
+
+	mock.AssertExpectations(t)
+}
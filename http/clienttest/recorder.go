@@ -0,0 +1,176 @@
+package clienttest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	party "github.com/h4lim/client-party"
+	"github.com/h4lim/og-kds/http"
+)
+
+const (
+	ModeReplay = "replay"
+	ModeRecord = "record"
+)
+
+// Recorder records the exchanges of a real transport to a golden file, or
+// replays them from it without touching the network.
+type Recorder struct {
+	mode       string
+	goldenFile string
+	next       http.Transport
+
+	mu        sync.Mutex
+	exchanges []*Exchange
+}
+
+type Exchange struct {
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     string          `json:"body,omitempty"`
+	Response *party.Response `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	used     bool
+}
+
+// NewRecorder loads goldenFile in ModeReplay. In ModeRecord requests go to
+// next, or http.PartyTransport when nil, and Save writes them out.
+func NewRecorder(mode string, goldenFile string, next http.Transport) (*Recorder, error) {
+	if next == nil {
+		next = http.PartyTransport{}
+	}
+
+	recorder := &Recorder{
+		mode:       mode,
+		goldenFile: goldenFile,
+		next:       next,
+	}
+
+	if mode != ModeReplay {
+		return recorder, nil
+	}
+
+	data, err := os.ReadFile(goldenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &recorder.exchanges); err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+// RoundTrip implements http.Transport.
+func (r *Recorder) RoundTrip(ctx context.Context, request http.ClientRequest) (*party.Response, error) {
+	body := ""
+	if request.RequestBody != nil {
+		body = *request.RequestBody
+	}
+
+	if r.mode == ModeRecord {
+		response, err := r.next.RoundTrip(ctx, request)
+
+		exchange := &Exchange{
+			Method:   strings.ToUpper(request.HttpMethod),
+			URL:      request.URL,
+			Body:     body,
+			Response: response,
+		}
+		if err != nil {
+			exchange.Error = err.Error()
+		}
+
+		r.mu.Lock()
+		r.exchanges = append(r.exchanges, exchange)
+		r.mu.Unlock()
+
+		return response, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, exchange := range r.exchanges {
+		if exchange.used || exchange.Method != strings.ToUpper(request.HttpMethod) ||
+			exchange.URL != request.URL || !sameBody(exchange.Body, body) {
+			continue
+		}
+
+		exchange.used = true
+		if exchange.Error != "" {
+			return nil, errors.New(exchange.Error)
+		}
+
+		if exchange.Response == nil {
+			return nil, errors.New("clienttest: recorded exchange for " + describe(request) + " has no response")
+		}
+
+		response := *exchange.Response
+		return &response, nil
+	}
+
+	return nil, errors.New("clienttest: no recorded exchange for " + describe(request))
+}
+
+// Save writes the recorded exchanges to the golden file. It does nothing
+// in ModeReplay.
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := json.MarshalIndent(r.exchanges, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.goldenFile), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(r.goldenFile, data, 0644)
+}
+
+// Unused returns the recorded exchanges that were never replayed.
+func (r *Recorder) Unused() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Exchange
+	for _, exchange := range r.exchanges {
+		if !exchange.used {
+			unused = append(unused, *exchange)
+		}
+	}
+
+	return unused
+}
+
+// sameBody compares JSON bodies by value and anything else byte for byte.
+func sameBody(expected string, actual string) bool {
+	if expected == actual {
+		return true
+	}
+
+	var expectedJson, actualJson any
+	if json.Unmarshal([]byte(expected), &expectedJson) != nil ||
+		json.Unmarshal([]byte(actual), &actualJson) != nil {
+		return false
+	}
+
+	expectedBytes, _ := json.Marshal(expectedJson)
+	actualBytes, _ := json.Marshal(actualJson)
+
+	return bytes.Equal(expectedBytes, actualBytes)
+}
@@ -0,0 +1,66 @@
+package clienttest
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorderRecordAndReplay(t *testing.T) {
+	goldenFile := filepath.Join(t.TempDir(), "golden", "partner.json")
+
+	mock := NewMock()
+	mock.Expect("POST", "http://partner/pay").RespondJson(201, `{"id":"1"}`)
+	mock.Expect("GET", "http://partner/down").RespondError(errors.New("connection reset"))
+
+	recorder, err := NewRecorder(ModeRecord, goldenFile, mock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"amount":1}`
+	if _, err := hit(recorder, "POST", "http://partner/pay", &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := hit(recorder, "GET", "http://partner/down", nil); err == nil {
+		t.Fatal("expected the recorded error")
+	}
+
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := NewRecorder(ModeReplay, goldenFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replay.Unused()) != 2 {
+		t.Fatalf("expected 2 unused exchanges, got %d", len(replay.Unused()))
+	}
+
+	replayBody := `{ "amount": 1 }`
+	response, err := hit(replay, "POST", "http://partner/pay", &replayBody)
+	if err != nil || response.HttpCode != 201 || response.ResponseBody != `{"id":"1"}` {
+		t.Fatalf("unexpected replay %v %v", response, err)
+	}
+
+	if _, err := hit(replay, "POST", "http://partner/pay", &replayBody); err == nil {
+		t.Fatal("expected an exchange to be replayed only once")
+	}
+
+	if _, err := hit(replay, "GET", "http://partner/down", nil); err == nil || err.Error() != "connection reset" {
+		t.Fatalf("expected the recorded error, got %v", err)
+	}
+
+	if len(replay.Unused()) != 0 {
+		t.Fatalf("expected every exchange to be used, got %v", replay.Unused())
+	}
+}
+
+func TestRecorderMissingGoldenFile(t *testing.T) {
+	if _, err := NewRecorder(ModeReplay, filepath.Join(t.TempDir(), "missing.json"), nil); err == nil {
+		t.Fatal("expected a missing golden file to fail")
+	}
+}
@@ -0,0 +1,309 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/h4lim/og-kds/infra"
+)
+
+var (
+	cors     *corsEngine
+	corsOnce sync.Once
+)
+
+// CorsModel configures CorsPolicy. AllowOrigins entries are exact origins,
+// "*" for any origin, wildcards like https://*.example.com or regular
+// expressions prefixed with "regex:", which must match the whole origin. "*" cannot be combined with
+// AllowCredentials. Routes overrides the policy for the paths under its
+// keys, matched on whole path segments and the longest prefix winning;
+// empty fields of a route are taken from the base model, and
+// DenyCredentials turns off the credentials a route would inherit.
+type CorsModel struct {
+	AllowOrigins     []string
+	AllowMethods     []string
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	DenyCredentials  bool
+	MaxAge           time.Duration
+	Routes           map[string]CorsModel
+}
+
+type corsEngine struct {
+	base   *corsRule
+	routes []corsRoute
+}
+
+type corsRoute struct {
+	prefix string
+	rule   *corsRule
+}
+
+type corsRule struct {
+	allowAll         bool
+	origins          map[string]bool
+	patterns         []*regexp.Regexp
+	allowAllHeaders  bool
+	allowMethods     string
+	allowHeaders     string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           string
+}
+
+var defaultCorsModel = CorsModel{
+	AllowOrigins: []string{"*"},
+	AllowMethods: []string{"POST", "OPTIONS", "GET", "PUT", "PATCH", "DELETE"},
+	AllowHeaders: []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token",
+		"Authorization", "accept", "Origin", "Cache-Control", "X-Requested-With", "Access-ID",
+		"Host", "Connection", "Pragma", "sec-ch-ua-mobile", "User-Agent", "sec-ch-ua", "Sec-Fetch-Site",
+		"Sec-Fetch-Mode", "Sec-Fetch-Dest", "Referer"},
+	MaxAge: 12 * time.Hour,
+}
+
+// NewCorsModelFromConfig reads the cors_allow_origins, cors_allow_methods,
+// cors_allow_headers, cors_expose_headers (comma separated),
+// cors_allow_credentials and cors_max_age (seconds or a duration) keys of
+// the toml config.
+func NewCorsModelFromConfig() CorsModel {
+	var model CorsModel
+
+	model.AllowOrigins = splitConfigList(infra.ConfigString["cors_allow_origins"])
+	model.AllowMethods = splitConfigList(infra.ConfigString["cors_allow_methods"])
+	model.AllowHeaders = splitConfigList(infra.ConfigString["cors_allow_headers"])
+	model.ExposeHeaders = splitConfigList(infra.ConfigString["cors_expose_headers"])
+
+	if credentials, ok := infra.ConfigBool["cors_allow_credentials"]; ok {
+		model.AllowCredentials = credentials
+	} else {
+		model.AllowCredentials, _ = strconv.ParseBool(infra.ConfigString["cors_allow_credentials"])
+	}
+
+	if maxAge, ok := infra.ConfigInt["cors_max_age"]; ok {
+		model.MaxAge = time.Duration(maxAge) * time.Second
+	} else if maxAge, err := time.ParseDuration(infra.ConfigString["cors_max_age"]); err == nil {
+		model.MaxAge = maxAge
+	}
+
+	return model
+}
+
+// setCors compiles the policy of model, falling back to the toml config and
+// then to the default policy allowing any origin without credentials.
+func setCors(model CorsModel) *error {
+	if len(model.AllowOrigins) == 0 && len(model.Routes) == 0 {
+		model = NewCorsModelFromConfig()
+	}
+
+	if len(model.AllowOrigins) == 0 {
+		model.AllowOrigins = defaultCorsModel.AllowOrigins
+	}
+
+	base, err := compileCorsRule(model, defaultCorsModel)
+	if err != nil {
+		return err
+	}
+
+	engine := &corsEngine{base: base}
+	for prefix, routeModel := range model.Routes {
+		rule, err := compileCorsRule(routeModel, model)
+		if err != nil {
+			return err
+		}
+
+		engine.routes = append(engine.routes, corsRoute{prefix: prefix, rule: rule})
+	}
+
+	sort.Slice(engine.routes, func(i, j int) bool {
+		return len(engine.routes[i].prefix) > len(engine.routes[j].prefix)
+	})
+
+	cors = engine
+	return nil
+}
+
+func getCors() *corsEngine {
+	corsOnce.Do(func() {
+		if cors != nil {
+			return
+		}
+
+		if err := setCors(CorsModel{}); err != nil {
+			fmt.Println("error cors", *err)
+			_ = setCors(defaultCorsModel)
+		}
+	})
+
+	return cors
+}
+
+func compileCorsRule(model CorsModel, parent CorsModel) (*corsRule, *error) {
+	if len(model.AllowOrigins) == 0 {
+		model.AllowOrigins = parent.AllowOrigins
+	}
+
+	if len(model.AllowMethods) == 0 {
+		model.AllowMethods = parent.AllowMethods
+	}
+
+	if len(model.AllowMethods) == 0 {
+		model.AllowMethods = defaultCorsModel.AllowMethods
+	}
+
+	if len(model.AllowHeaders) == 0 {
+		model.AllowHeaders = parent.AllowHeaders
+	}
+
+	if len(model.AllowHeaders) == 0 {
+		model.AllowHeaders = defaultCorsModel.AllowHeaders
+	}
+
+	if len(model.ExposeHeaders) == 0 {
+		model.ExposeHeaders = parent.ExposeHeaders
+	}
+
+	if !model.AllowCredentials && !model.DenyCredentials {
+		model.AllowCredentials = parent.AllowCredentials
+	}
+
+	if model.DenyCredentials {
+		model.AllowCredentials = false
+	}
+
+	if model.MaxAge == 0 {
+		model.MaxAge = parent.MaxAge
+	}
+
+	rule := &corsRule{
+		origins:          make(map[string]bool),
+		allowMethods:     strings.ToUpper(strings.Join(model.AllowMethods, ", ")),
+		allowHeaders:     strings.Join(model.AllowHeaders, ", "),
+		exposeHeaders:    strings.Join(model.ExposeHeaders, ", "),
+		allowCredentials: model.AllowCredentials,
+	}
+
+	if model.MaxAge > 0 {
+		rule.maxAge = strconv.Itoa(int(model.MaxAge.Seconds()))
+	}
+
+	for _, header := range model.AllowHeaders {
+		if header == "*" {
+			rule.allowAllHeaders = true
+		}
+	}
+
+	for _, origin := range model.AllowOrigins {
+		switch {
+		case origin == "*":
+			if model.AllowCredentials {
+				newError := errors.New("cors origin * cannot be combined with allow credentials")
+				return nil, &newError
+			}
+			rule.allowAll = true
+		case strings.HasPrefix(origin, "regex:"):
+			regex, err := regexp.Compile("^(?:" + strings.TrimPrefix(origin, "regex:") + ")$")
+			if err != nil {
+				newError := fmt.Errorf("invalid cors origin %s: %w", origin, err)
+				return nil, &newError
+			}
+			rule.patterns = append(rule.patterns, regex)
+		case strings.Contains(origin, "*"):
+			pattern := strings.ReplaceAll(regexp.QuoteMeta(strings.ToLower(origin)), `\*`, `[a-z0-9.-]*`)
+			rule.patterns = append(rule.patterns, regexp.MustCompile("^"+pattern+"$"))
+		default:
+			rule.origins[strings.ToLower(origin)] = true
+		}
+	}
+
+	return rule, nil
+}
+
+func (e *corsEngine) rule(path string) *corsRule {
+	for _, route := range e.routes {
+		if matchCorsPrefix(path, route.prefix) {
+			return route.rule
+		}
+	}
+
+	return e.base
+}
+
+// matchCorsPrefix matches prefix on whole path segments, so /api covers
+// /api and /api/users but not /apiv2.
+func matchCorsPrefix(path string, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+func (r *corsRule) isAllowed(origin string) bool {
+	if r.allowAll {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if r.origins[origin] {
+		return true
+	}
+
+	for _, pattern := range r.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// header writes the CORS headers for an allowed origin, including the
+// preflight ones when preflight is set.
+func (r *corsRule) header(header http.Header, origin string, preflight bool, requestHeaders string) {
+	if r.allowAll {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if r.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		if r.exposeHeaders != "" {
+			header.Set("Access-Control-Expose-Headers", r.exposeHeaders)
+		}
+		return
+	}
+
+	header.Set("Access-Control-Allow-Methods", r.allowMethods)
+	if r.allowAllHeaders && requestHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", requestHeaders)
+	} else if r.allowHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", r.allowHeaders)
+	}
+
+	if r.maxAge != "" {
+		header.Set("Access-Control-Max-Age", r.maxAge)
+	}
+}
+
+func splitConfigList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,81 @@
+package http
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strconv"
+	"strings"
+
+	party "github.com/h4lim/client-party"
+)
+
+// HttpError is returned by Do for a response outside the success codes,
+// with the body decoded into E when possible.
+type HttpError[E any] struct {
+	HttpCode int
+	Body     E
+	RawBody  string
+	Response *party.Response
+}
+
+func (e *HttpError[E]) Error() string {
+	return "unexpected http code " + strconv.Itoa(e.HttpCode) + ", response body " + e.RawBody
+}
+
+// Do hits client and decodes the response body into T, as XML when the
+// response content type says so and as JSON otherwise. A response with an
+// http code outside successCodes, or outside 2xx when none are given, is
+// returned as *HttpError[E]. The raw response is returned in every case it
+// exists, for logging.
+func Do[T any, E any](client IClient, successCodes ...int) (T, *party.Response, error) {
+	var result T
+
+	response, err := client.Hit().GetPartyResponse()
+	if err != nil {
+		return result, nil, err
+	}
+
+	if !isSuccessCode(response.HttpCode, successCodes) {
+		httpError := &HttpError[E]{
+			HttpCode: response.HttpCode,
+			RawBody:  response.ResponseBody,
+			Response: response,
+		}
+		_ = decodeBody(response, &httpError.Body)
+
+		return result, response, httpError
+	}
+
+	if strings.TrimSpace(response.ResponseBody) == "" {
+		return result, response, nil
+	}
+
+	if err := decodeBody(response, &result); err != nil {
+		return result, response, err
+	}
+
+	return result, response, nil
+}
+
+func isSuccessCode(httpCode int, successCodes []int) bool {
+	if len(successCodes) == 0 {
+		return httpCode >= 200 && httpCode < 300
+	}
+
+	for _, code := range successCodes {
+		if code == httpCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+func decodeBody(response *party.Response, v any) error {
+	contentType := strings.ToLower(responseHeaderValue(response, "Content-Type"))
+	if strings.Contains(contentType, "xml") {
+		return xml.Unmarshal([]byte(response.ResponseBody), v)
+	}
+
+	return json.Unmarshal([]byte(response.ResponseBody), v)
+}
@@ -0,0 +1,288 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h4lim/og-kds/infra"
+	"go.uber.org/zap"
+)
+
+const (
+	StorageRedis = "redis"
+	StorageCache = "cache"
+
+	idempotencyInFlight = "in-flight"
+	idempotencyDone     = "done"
+)
+
+// IdempotencyModel keeps the final response of a request for Window. The
+// in-flight marker of a request still being processed only lives for
+// InFlightTTL, so a crashed instance does not block retries for the whole
+// window; it should outlast the slowest handler.
+type IdempotencyModel struct {
+	Storage          string
+	Window           time.Duration
+	InFlightTTL      time.Duration
+	PartnerHeader    string
+	ExternalIdHeader string
+	ConflictHttpCode int
+	ConflictCode     string
+	Snap             bool
+}
+
+type idempotencyRecord struct {
+	Status      string `json:"status"`
+	HttpCode    int    `json:"http_code"`
+	ContentType string `json:"content_type"`
+	Body        string `json:"body"`
+}
+
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency replays the stored response of a request already answered
+// for the same partner, route and external id, and rejects a duplicate
+// while the first one is still being processed. 5xx responses, a timeout
+// included, are not kept so the request can be retried. It has to run after
+// DeliveryHandler so the request id of the body is known, and after
+// SnapVerify, SnapBearer or JwtAuth so the partner is the verified one;
+// otherwise it falls back to PartnerHeader, which the client controls.
+func (m mwContext) Idempotency(model IdempotencyModel) gin.HandlerFunc {
+
+	model = model.withDefaults()
+
+	return func(c *gin.Context) {
+		responseId, language := GetResponseIdAndLanguage(c)
+		externalId := c.GetHeader(model.ExternalIdHeader)
+		if externalId == "" {
+			externalId = GetRequestId(responseId)
+		}
+
+		if externalId == "" {
+			c.Next()
+			return
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+
+		key := "idempotency:" + callerPartnerId(c, model.PartnerHeader) + ":" + c.Request.Method + " " + route + ":" + externalId
+		ctx := c.Request.Context()
+
+		reserved, err := reserveIdempotency(ctx, model, key)
+		if err != nil {
+			warnIdempotency(responseId, key, *err)
+			c.Next()
+			return
+		}
+
+		if !reserved {
+			replayIdempotency(c, model, key, externalId, responseId, language)
+			return
+		}
+
+		// the marker goes away on a panic, a request aborted without a
+		// response or a 5xx, so a retry is not rejected as in flight
+		saved := false
+		defer func() {
+			if !saved {
+				_ = deleteIdempotency(context.WithoutCancel(ctx), model, key)
+			}
+		}()
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		if !writer.Written() || writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+
+		record := idempotencyRecord{
+			Status:      idempotencyDone,
+			HttpCode:    writer.Status(),
+			ContentType: writer.Header().Get("Content-Type"),
+			Body:        writer.body.String(),
+		}
+
+		if err := saveIdempotency(context.WithoutCancel(ctx), model, key, record); err != nil {
+			warnIdempotency(responseId, key, *err)
+			return
+		}
+
+		saved = true
+	}
+}
+
+func replayIdempotency(c *gin.Context, model IdempotencyModel, key string, externalId string, responseId int64, language string) {
+
+	record, err := loadIdempotency(c.Request.Context(), model, key)
+	if err != nil {
+		warnIdempotency(responseId, key, *err)
+		c.Next()
+		return
+	}
+
+	response := InitResponse(responseId, language)
+	if record.Status == idempotencyInFlight {
+		errConflict := errors.New("request " + externalId + " is still in progress")
+		optData := OptSetR{
+			HttpCode: model.ConflictHttpCode,
+			Code:     model.ConflictCode,
+		}
+
+		if model.Snap && model.ConflictCode == "" {
+			optData.SnapCase = &SnapConflict
+		}
+
+		response.SetErrorR(&errConflict, Tracer(), optData)
+		abortWithResponse(c, response, model.Snap)
+		return
+	}
+
+	response.HttpCode = record.HttpCode
+	response.Message = "idempotent replay of " + externalId
+	response.Tracer = Tracer()
+	response.BuildVoidResponse()
+
+	c.Header("X-Idempotent-Replay", "true")
+	c.Data(record.HttpCode, record.ContentType, []byte(record.Body))
+	c.Abort()
+}
+
+func (m IdempotencyModel) withDefaults() IdempotencyModel {
+
+	if m.Storage == "" {
+		m.Storage = StorageCache
+	}
+
+	if m.Window <= 0 {
+		m.Window = 24 * time.Hour
+	}
+
+	if m.InFlightTTL <= 0 {
+		m.InFlightTTL = time.Minute
+	}
+
+	if m.InFlightTTL > m.Window {
+		m.InFlightTTL = m.Window
+	}
+
+	if m.PartnerHeader == "" {
+		m.PartnerHeader = "X-Partner-Id"
+	}
+
+	if m.ExternalIdHeader == "" {
+		m.ExternalIdHeader = "X-External-ID"
+	}
+
+	if m.ConflictHttpCode == 0 {
+		m.ConflictHttpCode = http.StatusConflict
+	}
+
+	return m
+}
+
+func reserveIdempotency(ctx context.Context, model IdempotencyModel, key string) (bool, *error) {
+	return reserveKey(ctx, model.Storage, key, idempotencyRecord{Status: idempotencyInFlight}, model.InFlightTTL)
+}
+
+// reserveKey stores value under key only when the key does not exist yet,
+// in Redis or in infra.Cache depending on storage.
+func reserveKey(ctx context.Context, storage string, key string, value any, ttl time.Duration) (bool, *error) {
+
+	switch storage {
+	case StorageRedis:
+		if infra.RedisDB == nil {
+			newError := errors.New("redis is not initialized")
+			return false, &newError
+		}
+
+		return infra.RedisDB.SetNX(ctx, key, jsonMarshal(value), ttl)
+	default:
+		if infra.Cache == nil {
+			newError := errors.New("cache is not initialized")
+			return false, &newError
+		}
+
+		return infra.Cache.Add(key, value, ttl) == nil, nil
+	}
+}
+
+func loadIdempotency(ctx context.Context, model IdempotencyModel, key string) (idempotencyRecord, *error) {
+	var record idempotencyRecord
+
+	switch model.Storage {
+	case StorageRedis:
+		value, err := infra.RedisDB.Get(ctx, key)
+		if err != nil {
+			return record, err
+		}
+
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			return record, &err
+		}
+
+		return record, nil
+	default:
+		value, found := infra.Cache.Get(key)
+		if !found {
+			newError := errors.New("idempotency key expired: " + key)
+			return record, &newError
+		}
+
+		return value.(idempotencyRecord), nil
+	}
+}
+
+func saveIdempotency(ctx context.Context, model IdempotencyModel, key string, record idempotencyRecord) *error {
+
+	switch model.Storage {
+	case StorageRedis:
+		return infra.RedisDB.Set(ctx, key, jsonMarshal(record), model.Window)
+	default:
+		infra.Cache.Set(key, record, model.Window)
+		return nil
+	}
+}
+
+func deleteIdempotency(ctx context.Context, model IdempotencyModel, key string) *error {
+
+	switch model.Storage {
+	case StorageRedis:
+		_, err := infra.RedisDB.Delete(ctx, key)
+		return err
+	default:
+		infra.Cache.Delete(key)
+		return nil
+	}
+}
+
+func warnIdempotency(responseId int64, key string, err error) {
+	if infra.ZapLog != nil {
+		infra.ZapLog.Warn(strconv.FormatInt(responseId, 10),
+			zap.String("idempotency-key", key),
+			zap.String("error", err.Error()))
+	}
+}
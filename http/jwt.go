@@ -0,0 +1,692 @@
+package http
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	JwtHS256 = "HS256"
+	JwtRS256 = "RS256"
+	JwtES256 = "ES256"
+
+	jwtClaimsKey = "jwt-claims"
+
+	// maxClaimSeconds is about the year 10000.
+	maxClaimSeconds = 253402300800
+)
+
+var (
+	ErrJwtMissing   = errors.New("jwt: missing bearer token")
+	ErrJwtMalformed = errors.New("jwt: malformed token")
+	ErrJwtAlgorithm = errors.New("jwt: algorithm not allowed")
+	ErrJwtKey       = errors.New("jwt: no key for token")
+	ErrJwtSignature = errors.New("jwt: invalid signature")
+	ErrJwtExpired   = errors.New("jwt: token expired")
+	ErrJwtNotValid  = errors.New("jwt: token not valid yet")
+	ErrJwtIssuer    = errors.New("jwt: invalid issuer")
+	ErrJwtAudience  = errors.New("jwt: invalid audience")
+	ErrJwtForbidden = errors.New("jwt: missing required scope or role")
+)
+
+// JwtModel configures NewJwtAuth. Tokens are verified with Secret for
+// HS256, and for RS256 and ES256 with the PEM key of PublicKeyFile or the
+// keys of a JWKS read from JwksFile or fetched from JwksUrl, cached for
+// JwksCacheTTL. Issuer and Audience are checked when set, exp is required
+// and the time claims accept Leeway of clock skew.
+type JwtModel struct {
+	Algorithms        []string
+	Secret            string
+	PublicKeyFile     string
+	JwksFile          string
+	JwksUrl           string
+	JwksCacheTTL      time.Duration
+	Issuer            string
+	Audience          string
+	Leeway            time.Duration
+	ScopeClaim        string
+	RoleClaim         string
+	Snap              bool
+	ServiceCode       string
+	HttpCode          int
+	Code              string
+	ForbiddenHttpCode int
+	ForbiddenCode     string
+}
+
+type JwtClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	Id        string
+	Scopes    []string
+	Roles     []string
+	Raw       map[string]any
+}
+
+type IJwtAuth interface {
+	Authenticate(c *gin.Context)
+	RequireScopes(scopes ...string) gin.HandlerFunc
+	RequireRoles(roles ...string) gin.HandlerFunc
+	Verify(token string) (*JwtClaims, error)
+}
+
+type jwtAuth struct {
+	model     JwtModel
+	publicKey crypto.PublicKey
+	jwks      *jwksCache
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwksCache struct {
+	mu        sync.RWMutex
+	group     singleflight.Group
+	url       string
+	ttl       time.Duration
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	client    *http.Client
+}
+
+// JwtAuth is NewJwtAuth on IMw.
+func (m mwContext) JwtAuth(model JwtModel) (IJwtAuth, *error) {
+	return NewJwtAuth(model)
+}
+
+func NewJwtAuth(model JwtModel) (IJwtAuth, *error) {
+
+	if model.JwksCacheTTL <= 0 {
+		model.JwksCacheTTL = 10 * time.Minute
+	}
+
+	if model.ScopeClaim == "" {
+		model.ScopeClaim = "scope"
+	}
+
+	if model.RoleClaim == "" {
+		model.RoleClaim = "roles"
+	}
+
+	if model.HttpCode == 0 {
+		model.HttpCode = http.StatusUnauthorized
+	}
+
+	if model.ForbiddenHttpCode == 0 {
+		model.ForbiddenHttpCode = http.StatusForbidden
+	}
+
+	auth := &jwtAuth{model: model}
+
+	if model.PublicKeyFile != "" {
+		block, err := readPem(model.PublicKeyFile)
+		if err != nil {
+			return nil, &err
+		}
+
+		publicKey, err := parseJwtPublicKey(block)
+		if err != nil {
+			return nil, &err
+		}
+		auth.publicKey = publicKey
+	}
+
+	if model.JwksFile != "" || model.JwksUrl != "" {
+		auth.jwks = &jwksCache{
+			url:    model.JwksUrl,
+			ttl:    model.JwksCacheTTL,
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+
+		if model.JwksFile != "" {
+			data, err := os.ReadFile(model.JwksFile)
+			if err != nil {
+				return nil, &err
+			}
+
+			keys, err := parseJwks(data)
+			if err != nil {
+				return nil, &err
+			}
+			auth.jwks.keys = keys
+		}
+	}
+
+	if len(model.Algorithms) == 0 {
+		if model.Secret != "" {
+			auth.model.Algorithms = append(auth.model.Algorithms, JwtHS256)
+		}
+
+		if auth.publicKey != nil || auth.jwks != nil {
+			auth.model.Algorithms = append(auth.model.Algorithms, JwtRS256, JwtES256)
+		}
+	}
+
+	if len(auth.model.Algorithms) == 0 {
+		newError := errors.New("jwt: no secret, public key or jwks configured")
+		return nil, &newError
+	}
+
+	return auth, nil
+}
+
+// Authenticate verifies the bearer token of the request and stores its
+// claims for GetJwtClaims. It has to run after DeliveryHandler.
+func (a *jwtAuth) Authenticate(c *gin.Context) {
+
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found || token == "" {
+		a.abort(c, ErrJwtMissing, false)
+		return
+	}
+
+	claims, err := a.Verify(token)
+	if err != nil {
+		a.abort(c, err, false)
+		return
+	}
+
+	c.Set(jwtClaimsKey, claims)
+	c.Next()
+}
+
+// RequireScopes lets the request through only when the claims stored by
+// Authenticate hold every scope.
+func (a *jwtAuth) RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetJwtClaims(c)
+		if !ok || !containsAll(claims.Scopes, scopes) {
+			a.abort(c, ErrJwtForbidden, true)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRoles lets the request through when the claims stored by
+// Authenticate hold any of roles.
+func (a *jwtAuth) RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetJwtClaims(c)
+		if !ok || !containsAny(claims.Roles, roles) {
+			a.abort(c, ErrJwtForbidden, true)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (a *jwtAuth) Verify(token string) (*JwtClaims, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJwtMalformed
+	}
+
+	var header jwtHeader
+	if err := decodeJwtPart(parts[0], &header); err != nil {
+		return nil, ErrJwtMalformed
+	}
+
+	if !containsAny(a.model.Algorithms, []string{header.Alg}) {
+		return nil, ErrJwtAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJwtMalformed
+	}
+
+	if err := a.verifySignature(header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]any)
+	if err := decodeJwtPart(parts[1], &raw); err != nil {
+		return nil, ErrJwtMalformed
+	}
+
+	claims := a.claims(raw)
+	if err := a.validate(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (a *jwtAuth) verifySignature(header jwtHeader, signingInput string, signature []byte) error {
+	hash := sha256.Sum256([]byte(signingInput))
+
+	switch header.Alg {
+	case JwtHS256:
+		if a.model.Secret == "" {
+			return ErrJwtKey
+		}
+
+		mac := hmac.New(sha256.New, []byte(a.model.Secret))
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrJwtSignature
+		}
+	case JwtRS256:
+		publicKey, ok := a.key(header.Kid, "RSA").(*rsa.PublicKey)
+		if !ok {
+			return ErrJwtKey
+		}
+
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature) != nil {
+			return ErrJwtSignature
+		}
+	case JwtES256:
+		publicKey, ok := a.key(header.Kid, "EC").(*ecdsa.PublicKey)
+		if !ok {
+			return ErrJwtKey
+		}
+
+		if len(signature) != 64 {
+			return ErrJwtSignature
+		}
+
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(publicKey, hash[:], r, s) {
+			return ErrJwtSignature
+		}
+	default:
+		return ErrJwtAlgorithm
+	}
+
+	return nil
+}
+
+// key picks the key of kid from the JWKS, or the configured public key.
+func (a *jwtAuth) key(kid string, kty string) crypto.PublicKey {
+	if a.jwks != nil {
+		if key := a.jwks.get(kid, kty); key != nil {
+			return key
+		}
+	}
+
+	return a.publicKey
+}
+
+func (a *jwtAuth) claims(raw map[string]any) *JwtClaims {
+	claims := &JwtClaims{
+		Issuer:    claimString(raw["iss"]),
+		Subject:   claimString(raw["sub"]),
+		Audience:  claimStrings(raw["aud"]),
+		ExpiresAt: claimTime(raw["exp"]),
+		NotBefore: claimTime(raw["nbf"]),
+		IssuedAt:  claimTime(raw["iat"]),
+		Id:        claimString(raw["jti"]),
+		Scopes:    claimStrings(raw[a.model.ScopeClaim]),
+		Roles:     claimStrings(raw[a.model.RoleClaim]),
+		Raw:       raw,
+	}
+
+	if len(claims.Scopes) == 0 && a.model.ScopeClaim == "scope" {
+		claims.Scopes = claimStrings(raw["scp"])
+	}
+
+	return claims
+}
+
+func (a *jwtAuth) validate(claims *JwtClaims) error {
+	now := time.Now()
+
+	if claims.ExpiresAt.IsZero() || now.After(claims.ExpiresAt.Add(a.model.Leeway)) {
+		return ErrJwtExpired
+	}
+
+	if !claims.NotBefore.IsZero() && now.Add(a.model.Leeway).Before(claims.NotBefore) {
+		return ErrJwtNotValid
+	}
+
+	if !claims.IssuedAt.IsZero() && now.Add(a.model.Leeway).Before(claims.IssuedAt) {
+		return ErrJwtNotValid
+	}
+
+	if a.model.Issuer != "" && claims.Issuer != a.model.Issuer {
+		return ErrJwtIssuer
+	}
+
+	if a.model.Audience != "" && !containsAny(claims.Audience, []string{a.model.Audience}) {
+		return ErrJwtAudience
+	}
+
+	return nil
+}
+
+func (a *jwtAuth) abort(c *gin.Context, err error, forbidden bool) {
+	responseId, language := GetResponseIdAndLanguage(c)
+	response := InitResponse(responseId, language)
+
+	optData := OptSetR{
+		HttpCode:    a.model.HttpCode,
+		Code:        a.model.Code,
+		ServiceCode: a.model.ServiceCode,
+	}
+
+	snapCase := &SnapInvalidToken
+	if errors.Is(err, ErrJwtMissing) {
+		snapCase = &SnapUnauthorized
+	}
+
+	if forbidden {
+		optData.HttpCode = a.model.ForbiddenHttpCode
+		optData.Code = a.model.ForbiddenCode
+		snapCase = &SnapFeatureNotAllowed
+	}
+
+	if a.model.Snap && optData.Code == "" {
+		optData.SnapCase = snapCase
+	}
+
+	response.SetErrorR(&err, Tracer(), optData)
+	abortWithResponse(c, response, a.model.Snap)
+}
+
+func GetJwtClaims(c *gin.Context) (*JwtClaims, bool) {
+	value, exist := c.Get(jwtClaimsKey)
+	if !exist {
+		return nil, false
+	}
+
+	claims, ok := value.(*JwtClaims)
+	return claims, ok
+}
+
+// GetJwtClaimsAs decodes the raw claims stored by Authenticate into T.
+func GetJwtClaimsAs[T any](c *gin.Context) (T, error) {
+	var result T
+
+	claims, ok := GetJwtClaims(c)
+	if !ok {
+		return result, ErrJwtMissing
+	}
+
+	data, err := json.Marshal(claims.Raw)
+	if err != nil {
+		return result, err
+	}
+
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// get returns the key of kid, fetching the JWKS again when the cache is
+// older than its ttl, or when kid is unknown at most once a minute. The
+// fetch runs outside the lock and once for all the requests waiting on it.
+func (j *jwksCache) get(kid string, kty string) crypto.PublicKey {
+	j.mu.RLock()
+	key, refresh := j.lookup(kid, kty)
+	j.mu.RUnlock()
+
+	if !refresh {
+		return key
+	}
+
+	j.group.Do("jwks", func() (any, error) {
+		j.mu.RLock()
+		_, refresh := j.lookup(kid, kty)
+		j.mu.RUnlock()
+
+		if !refresh {
+			return nil, nil
+		}
+
+		keys, err := j.fetch()
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		if err == nil {
+			j.keys = keys
+		}
+		j.fetchedAt = time.Now()
+
+		return nil, nil
+	})
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.find(kid, kty)
+}
+
+func (j *jwksCache) lookup(kid string, kty string) (crypto.PublicKey, bool) {
+	key := j.find(kid, kty)
+	if j.url == "" {
+		return key, false
+	}
+
+	age := time.Since(j.fetchedAt)
+	return key, age > j.ttl || (key == nil && age > time.Minute)
+}
+
+// find looks kid up, or takes the only key of kty when the token has no kid.
+func (j *jwksCache) find(kid string, kty string) crypto.PublicKey {
+	if kid != "" {
+		return j.keys[kid]
+	}
+
+	var found crypto.PublicKey
+	for _, key := range j.keys {
+		_, isRsa := key.(*rsa.PublicKey)
+		_, isEc := key.(*ecdsa.PublicKey)
+		if (kty == "RSA" && isRsa) || (kty == "EC" && isEc) {
+			if found != nil {
+				return nil
+			}
+			found = key
+		}
+	}
+
+	return found
+}
+
+func (j *jwksCache) fetch() (map[string]crypto.PublicKey, error) {
+	response, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwt: jwks %s answered %d", j.url, response.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseJwks(data)
+}
+
+func parseJwks(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for i, key := range set.Keys {
+		publicKey, err := key.publicKey()
+		if err != nil {
+			continue
+		}
+
+		kid := key.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("#%d", i)
+		}
+		keys[kid] = publicKey
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			return nil, ErrJwtKey
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, ErrJwtKey
+		}
+
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, ErrJwtKey
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, ErrJwtKey
+	}
+}
+
+func parseJwtPublicKey(block *pem.Block) (crypto.PublicKey, error) {
+	key, err := parsePemPublicKey(block)
+	if err != nil {
+		return nil, err
+	}
+
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		return publicKey, nil
+	case *ecdsa.PublicKey:
+		if publicKey.Curve != elliptic.P256() {
+			return nil, errors.New("jwt: ES256 needs a P-256 key")
+		}
+		return publicKey, nil
+	default:
+		return nil, errors.New("jwt: unsupported public key type")
+	}
+}
+
+func decodeJwtPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func claimString(value any) string {
+	s, _ := value.(string)
+	return s
+}
+
+// claimStrings reads a claim holding either a space separated string or an
+// array of strings.
+func claimStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+
+	return nil
+}
+
+// claimTime reads a NumericDate, clamped to maxClaimSeconds so a huge exp
+// or nbf cannot overflow.
+func claimTime(value any) time.Time {
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}
+	}
+
+	seconds, err := number.Float64()
+	if err != nil && !math.IsInf(seconds, 0) {
+		return time.Time{}
+	}
+
+	seconds = math.Max(-maxClaimSeconds, math.Min(seconds, maxClaimSeconds))
+	whole, fraction := math.Modf(seconds)
+
+	return time.Unix(int64(whole), int64(fraction*float64(time.Second)))
+}
+
+func containsAll(list []string, values []string) bool {
+	for _, value := range values {
+		if !containsAny(list, []string{value}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsAny(list []string, values []string) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if item == value {
+				return true
+			}
+		}
+	}
+
+	return false
+}
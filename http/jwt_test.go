@@ -0,0 +1,292 @@
+package http
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testJwtSecret = "s3cret"
+
+func encodeJwtPart(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signTestJwt builds a token with a raw claims payload, so that claims
+// like exp can hold values json.Marshal would not produce.
+func signTestJwt(t *testing.T, alg string, kid string, payload string, key any) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	signingInput := encodeJwtPart(header) + "." + encodeJwtPart([]byte(payload))
+	hash := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	}
+
+	return signingInput + "." + encodeJwtPart(signature)
+}
+
+func jwtPayload(claims map[string]any) string {
+	data, _ := json.Marshal(claims)
+	return string(data)
+}
+
+func writeTestPublicKey(t *testing.T, publicKey any) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "public.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func ecJwk(kid string, crv string, publicKey *ecdsa.PublicKey) map[string]string {
+	size := (publicKey.Curve.Params().BitSize + 7) / 8
+	x := make([]byte, size)
+	y := make([]byte, size)
+	publicKey.X.FillBytes(x)
+	publicKey.Y.FillBytes(y)
+
+	return map[string]string{"kty": "EC", "kid": kid, "crv": crv, "x": encodeJwtPart(x), "y": encodeJwtPart(y)}
+}
+
+func rsaJwk(kid string, publicKey *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"n":   encodeJwtPart(publicKey.N.Bytes()),
+		"e":   encodeJwtPart(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
+func writeTestJwks(t *testing.T, keys ...map[string]string) string {
+	t.Helper()
+
+	data, _ := json.Marshal(map[string]any{"keys": keys})
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestJwtVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	auth, err := NewJwtAuth(JwtModel{
+		Algorithms: []string{JwtHS256, JwtRS256, JwtES256},
+		Secret:     testJwtSecret,
+		JwksFile:   writeTestJwks(t, rsaJwk("rsa", &rsaKey.PublicKey), ecJwk("ec", "P-256", &ecKey.PublicKey), ecJwk("p384", "P-384", &p384Key.PublicKey)),
+		Issuer:     "issuer",
+		Audience:   "api",
+		Leeway:     5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(*err)
+	}
+
+	now := time.Now().Unix()
+	claims := func(change func(claims map[string]any)) string {
+		base := map[string]any{"iss": "issuer", "aud": []string{"api", "other"}, "sub": "user", "exp": now + 60}
+		if change != nil {
+			change(base)
+		}
+		return jwtPayload(base)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"hs256", signTestJwt(t, JwtHS256, "", claims(nil), []byte(testJwtSecret)), nil},
+		{"rs256", signTestJwt(t, JwtRS256, "rsa", claims(nil), rsaKey), nil},
+		{"es256", signTestJwt(t, JwtES256, "ec", claims(nil), ecKey), nil},
+		{"alg none", signTestJwt(t, "none", "", claims(nil), []byte(testJwtSecret)), ErrJwtAlgorithm},
+		{"alg hs384", signTestJwt(t, "HS384", "", claims(nil), []byte(testJwtSecret)), ErrJwtAlgorithm},
+		{"alg mismatch", signTestJwt(t, JwtRS256, "ec", claims(nil), ecKey), ErrJwtKey},
+		{"wrong secret", signTestJwt(t, JwtHS256, "", claims(nil), []byte("other")), ErrJwtSignature},
+		{"wrong key", signTestJwt(t, JwtES256, "ec", claims(nil), p384Key), ErrJwtSignature},
+		{"curve p384", signTestJwt(t, JwtES256, "p384", claims(nil), p384Key), ErrJwtKey},
+		{"unknown kid", signTestJwt(t, JwtRS256, "missing", claims(nil), rsaKey), ErrJwtKey},
+		{"malformed", "a.b", ErrJwtMalformed},
+		{"exp missing", signTestJwt(t, JwtHS256, "", claims(func(c map[string]any) { delete(c, "exp") }), []byte(testJwtSecret)), ErrJwtExpired},
+		{"exp past", signTestJwt(t, JwtHS256, "", claims(func(c map[string]any) { c["exp"] = now - 60 }), []byte(testJwtSecret)), ErrJwtExpired},
+		{"exp within leeway", signTestJwt(t, JwtHS256, "", claims(func(c map[string]any) { c["exp"] = now - 2 }), []byte(testJwtSecret)), nil},
+		{"exp huge", signTestJwt(t, JwtHS256, "", `{"iss":"issuer","aud":"api","exp":1e300}`, []byte(testJwtSecret)), nil},
+		{"exp overflow", signTestJwt(t, JwtHS256, "", `{"iss":"issuer","aud":"api","exp":1e400}`, []byte(testJwtSecret)), nil},
+		{"nbf future", signTestJwt(t, JwtHS256, "", claims(func(c map[string]any) { c["nbf"] = now + 60 }), []byte(testJwtSecret)), ErrJwtNotValid},
+		{"nbf huge", signTestJwt(t, JwtHS256, "", `{"iss":"issuer","aud":"api","exp":1e300,"nbf":1e300}`, []byte(testJwtSecret)), ErrJwtNotValid},
+		{"nbf within leeway", signTestJwt(t, JwtHS256, "", claims(func(c map[string]any) { c["nbf"] = now + 2 }), []byte(testJwtSecret)), nil},
+		{"iat future", signTestJwt(t, JwtHS256, "", claims(func(c map[string]any) { c["iat"] = now + 60 }), []byte(testJwtSecret)), ErrJwtNotValid},
+		{"aud string", signTestJwt(t, JwtHS256, "", claims(func(c map[string]any) { c["aud"] = "api" }), []byte(testJwtSecret)), nil},
+		{"aud other", signTestJwt(t, JwtHS256, "", claims(func(c map[string]any) { c["aud"] = []string{"other"} }), []byte(testJwtSecret)), ErrJwtAudience},
+		{"aud missing", signTestJwt(t, JwtHS256, "", claims(func(c map[string]any) { delete(c, "aud") }), []byte(testJwtSecret)), ErrJwtAudience},
+		{"iss other", signTestJwt(t, JwtHS256, "", claims(func(c map[string]any) { c["iss"] = "other" }), []byte(testJwtSecret)), ErrJwtIssuer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := auth.Verify(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestJwtPublicKeyCurve(t *testing.T) {
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := NewJwtAuth(JwtModel{PublicKeyFile: writeTestPublicKey(t, &p256Key.PublicKey)}); err != nil {
+		t.Fatal(*err)
+	}
+
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if _, err := NewJwtAuth(JwtModel{PublicKeyFile: writeTestPublicKey(t, &p384Key.PublicKey)}); err == nil {
+		t.Fatal("expected a P-384 public key to be rejected")
+	}
+}
+
+func TestJwtJwksFetchedOnce(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(50 * time.Millisecond)
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{rsaJwk("rsa", &rsaKey.PublicKey)}})
+	}))
+	defer server.Close()
+
+	auth, err := NewJwtAuth(JwtModel{JwksUrl: server.URL})
+	if err != nil {
+		t.Fatal(*err)
+	}
+
+	token := signTestJwt(t, JwtRS256, "rsa", jwtPayload(map[string]any{"exp": time.Now().Unix() + 60}), rsaKey)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := auth.Verify(token); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count := atomic.LoadInt32(&fetches); count != 1 {
+		t.Fatalf("expected one jwks fetch, got %d", count)
+	}
+}
+
+func TestJwtAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mw := NewMw()
+	auth, err := mw.JwtAuth(JwtModel{Secret: testJwtSecret, Snap: true, ServiceCode: "18"})
+	if err != nil {
+		t.Fatal(*err)
+	}
+
+	router := gin.New()
+	router.Use(mw.DeliveryHandler)
+	router.GET("/jwt", auth.Authenticate, auth.RequireScopes("read"), auth.RequireRoles("admin", "ops"), func(c *gin.Context) {
+		responseId, _ := GetResponseIdAndLanguage(c)
+		ReleaseTrace(responseId)
+
+		claims, err := GetJwtClaimsAs[struct {
+			Subject string `json:"sub"`
+		}](c)
+		if err != nil {
+			t.Error(err)
+		}
+
+		c.String(http.StatusOK, claims.Subject)
+	})
+
+	exp := time.Now().Unix() + 60
+	tests := []struct {
+		name     string
+		claims   map[string]any
+		wantCode int
+		wantBody string
+	}{
+		{"allowed", map[string]any{"sub": "user", "exp": exp, "scope": "read write", "roles": []string{"ops"}}, http.StatusOK, "user"},
+		{"no token", nil, http.StatusUnauthorized, "4011800"},
+		{"expired", map[string]any{"sub": "user", "exp": exp - 120, "scope": "read", "roles": "admin"}, http.StatusUnauthorized, "4011801"},
+		{"missing scope", map[string]any{"sub": "user", "exp": exp, "scope": "write", "roles": "admin"}, http.StatusForbidden, "4031801"},
+		{"missing role", map[string]any{"sub": "user", "exp": exp, "scope": "read", "roles": "user"}, http.StatusForbidden, "4031801"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/jwt", nil)
+			if tt.claims != nil {
+				request.Header.Set("Authorization", "Bearer "+signTestJwt(t, JwtHS256, "", jwtPayload(tt.claims), []byte(testJwtSecret)))
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantCode || !strings.Contains(recorder.Body.String(), tt.wantBody) {
+				t.Fatalf("expected %d %s, got %d %s", tt.wantCode, tt.wantBody, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+
+	if count := countTraces(); count != 0 {
+		t.Fatalf("%d traces left after the requests", count)
+	}
+}
@@ -0,0 +1,306 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/h4lim/og-kds/infra"
+	"go.uber.org/zap"
+)
+
+// LogEntry is the request audit row handed to every LogSink.
+type LogEntry = sqlLog
+
+// LogSink writes batches of entries. A Write that fails after some entries
+// went through returns a *LogSinkPartialError, any other error means none
+// were written; the sql log writer retries only what was not written.
+type LogSink interface {
+	Write(entries []LogEntry) error
+	Close() error
+}
+
+// LogSinkPartialError is returned by a LogSink that wrote the first Written
+// entries of a batch before failing.
+type LogSinkPartialError struct {
+	Written int
+	Err     error
+}
+
+func (e *LogSinkPartialError) Error() string {
+	return "log sink wrote " + strconv.Itoa(e.Written) + " entries: " + e.Err.Error()
+}
+
+func (e *LogSinkPartialError) Unwrap() error {
+	return e.Err
+}
+
+// logSinkWritten is how many entries of the batch a failed Write got
+// through.
+func logSinkWritten(err error) int {
+	var partial *LogSinkPartialError
+	if errors.As(err, &partial) {
+		return partial.Written
+	}
+
+	return 0
+}
+
+func partialLogSinkError(written int, err error) error {
+	if written == 0 {
+		return err
+	}
+
+	return &LogSinkPartialError{Written: written, Err: err}
+}
+
+type FileLogSinkModel struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+}
+
+type MqttLogSinkModel struct {
+	Client  mqtt.Client
+	Topic   string
+	Qos     byte
+	Timeout time.Duration
+}
+
+type gormLogSink struct {
+	batchSize int
+}
+
+type fileLogSink struct {
+	model FileLogSinkModel
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+type zapLogSink struct {
+	logger *zap.Logger
+}
+
+type mqttLogSink struct {
+	model MqttLogSinkModel
+}
+
+// NewGormLogSink writes entries to the sql_logs table of infra.GormDB.
+func NewGormLogSink(batchSize int) LogSink {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	return gormLogSink{
+		batchSize: batchSize,
+	}
+}
+
+// NewFileLogSink appends entries as JSON lines to model.Path. Once the file
+// grows past MaxSize bytes it is renamed with a timestamp suffix and only
+// the newest MaxBackups renamed files are kept.
+func NewFileLogSink(model FileLogSinkModel) (LogSink, *error) {
+	sink := &fileLogSink{
+		model: model,
+	}
+
+	if err := sink.open(); err != nil {
+		return nil, &err
+	}
+
+	return sink, nil
+}
+
+// NewZapLogSink logs entries through logger, or infra.ZapLog when nil.
+func NewZapLogSink(logger *zap.Logger) LogSink {
+	return zapLogSink{
+		logger: logger,
+	}
+}
+
+// NewMqttLogSink publishes every entry as JSON to model.Topic.
+func NewMqttLogSink(model MqttLogSinkModel) LogSink {
+	if model.Timeout <= 0 {
+		model.Timeout = 5 * time.Second
+	}
+
+	return mqttLogSink{
+		model: model,
+	}
+}
+
+func (g gormLogSink) Write(entries []LogEntry) error {
+	if infra.GormDB == nil {
+		return errors.New("gorm db is not initialized")
+	}
+
+	return infra.GormDB.CreateInBatches(&entries, g.batchSize).Error
+}
+
+func (g gormLogSink) Close() error {
+	return nil
+}
+
+func (f *fileLogSink) Write(entries []LogEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+
+	rotateFailed := false
+	for i, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return partialLogSinkError(i, err)
+		}
+		line = append(line, '\n')
+
+		if f.model.MaxSize > 0 && f.size > 0 && f.size+int64(len(line)) > f.model.MaxSize && !rotateFailed {
+			if err := f.rotate(); err != nil {
+				if f.file == nil {
+					return partialLogSinkError(i, err)
+				}
+
+				// keep appending to the current file rather than failing a
+				// batch that is partly written already
+				rotateFailed = true
+				warnLogSink(f.model.Path, err)
+			}
+		}
+
+		written, err := f.file.Write(line)
+		f.size += int64(written)
+		if err != nil {
+			return partialLogSinkError(i, err)
+		}
+	}
+
+	return nil
+}
+
+func (f *fileLogSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
+
+func (f *fileLogSink) open() error {
+	file, err := os.OpenFile(f.model.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+// rotate renames the current file to a backup and opens a new one. When
+// the rename fails the current file is opened again, so f.file is only nil
+// when even that failed.
+func (f *fileLogSink) rotate() error {
+	errClose := f.file.Close()
+	f.file = nil
+
+	backup := f.model.Path + "." + strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := errors.Join(errClose, os.Rename(f.model.Path, backup)); err != nil {
+		if errOpen := f.open(); errOpen != nil {
+			return errors.Join(err, errOpen)
+		}
+
+		return err
+	}
+
+	if f.model.MaxBackups > 0 {
+		backups, _ := filepath.Glob(f.model.Path + ".*")
+		sort.Strings(backups)
+		for len(backups) > f.model.MaxBackups {
+			_ = os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+
+	return f.open()
+}
+
+func (z zapLogSink) Write(entries []LogEntry) error {
+	logger := z.logger
+	if logger == nil {
+		logger = infra.ZapLog
+	}
+
+	if logger == nil {
+		return errors.New("zap logger is not initialized")
+	}
+
+	for _, entry := range entries {
+		logger.Info(entry.ResponseID,
+			zap.String("request-id", entry.RequestID),
+			zap.Int("step", entry.Step),
+			zap.String("code", entry.Code),
+			zap.String("message", entry.Message),
+			zap.String("function-name", entry.FunctionName),
+			zap.String("data", entry.Data),
+			zap.String("duration", entry.Duration),
+			zap.String("trace", entry.Tracer))
+	}
+
+	return nil
+}
+
+func (z zapLogSink) Close() error {
+	return nil
+}
+
+func (m mqttLogSink) Write(entries []LogEntry) error {
+	for i, entry := range entries {
+		token := m.model.Client.Publish(m.model.Topic, m.model.Qos, false, jsonMarshal(entry))
+		if !token.WaitTimeout(m.model.Timeout) {
+			return partialLogSinkError(i, errors.New("publish log entry to "+m.model.Topic+" timed out"))
+		}
+
+		if err := token.Error(); err != nil {
+			return partialLogSinkError(i, err)
+		}
+	}
+
+	return nil
+}
+
+func (m mqttLogSink) Close() error {
+	return nil
+}
+
+func warnLogSink(path string, err error) {
+	if infra.ZapLog != nil {
+		infra.ZapLog.Warn("rotate log file failed", zap.String("path", path),
+			zap.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,381 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var masking *maskEngine
+
+var (
+	MaskPAN = MaskPattern{
+		Name:      "pan",
+		Regex:     `\b\d{13,19}\b`,
+		KeepFirst: 4,
+		KeepLast:  4,
+		Luhn:      true,
+	}
+	MaskPhone = MaskPattern{
+		Name:      "phone",
+		Regex:     `(?:\+62|\b62|\b0)8\d{7,11}\b`,
+		KeepFirst: 4,
+		KeepLast:  3,
+	}
+	MaskEmail = MaskPattern{
+		Name:      "email",
+		Regex:     `\b(?P<mask>[A-Za-z0-9._%+-]+)@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`,
+		KeepFirst: 1,
+	}
+)
+
+// MaskModel configures the masking of logged data. Nil Headers and
+// Patterns take the defaults, the usual secret headers and MaskPAN,
+// MaskPhone and MaskEmail; an empty list turns them off.
+type MaskModel struct {
+	Enabled   bool
+	MaskChar  string
+	Headers   []string
+	JsonPaths []MaskJsonPath
+	Patterns  []MaskPattern
+}
+
+// MaskJsonPath is a dotted path into a JSON body, e.g. "card.number" or
+// "items.*.pin", where "*" matches any key or array index.
+type MaskJsonPath struct {
+	Path      string
+	KeepFirst int
+	KeepLast  int
+}
+
+// MaskPattern masks every match of Regex, or only its "mask" named group
+// when the regex has one. With Luhn only matches passing the Luhn check
+// are masked, so ids of the same length as a card number are left alone.
+type MaskPattern struct {
+	Name      string
+	Regex     string
+	KeepFirst int
+	KeepLast  int
+	Luhn      bool
+}
+
+type maskEngine struct {
+	maskChar  string
+	headers   map[string]bool
+	jsonPaths []compiledJsonPath
+	patterns  []compiledPattern
+}
+
+type compiledJsonPath struct {
+	parts     []string
+	keepFirst int
+	keepLast  int
+}
+
+type compiledPattern struct {
+	regex     *regexp.Regexp
+	group     int
+	keepFirst int
+	keepLast  int
+	luhn      bool
+}
+
+func setMasking(model MaskModel) *error {
+	if !model.Enabled {
+		masking = nil
+		return nil
+	}
+
+	if model.MaskChar == "" {
+		model.MaskChar = "*"
+	}
+
+	if model.Headers == nil {
+		model.Headers = []string{"Authorization", "Authorization-Customer", "Cookie",
+			"Set-Cookie", "X-Signature", "X-Client-Key", "X-Client-Secret"}
+	}
+
+	if model.Patterns == nil {
+		model.Patterns = []MaskPattern{MaskPAN, MaskPhone, MaskEmail}
+	}
+
+	engine := &maskEngine{
+		maskChar: model.MaskChar,
+		headers:  make(map[string]bool),
+	}
+
+	for _, header := range model.Headers {
+		engine.headers[strings.ToLower(header)] = true
+	}
+
+	for _, jsonPath := range model.JsonPaths {
+		if jsonPath.KeepFirst < 0 || jsonPath.KeepLast < 0 {
+			newError := errors.New("mask json path " + jsonPath.Path + " keeps a negative number of characters")
+			return &newError
+		}
+
+		engine.jsonPaths = append(engine.jsonPaths, compiledJsonPath{
+			parts:     strings.Split(jsonPath.Path, "."),
+			keepFirst: jsonPath.KeepFirst,
+			keepLast:  jsonPath.KeepLast,
+		})
+	}
+
+	for _, pattern := range model.Patterns {
+		if pattern.KeepFirst < 0 || pattern.KeepLast < 0 {
+			newError := errors.New("mask pattern " + pattern.Name + " keeps a negative number of characters")
+			return &newError
+		}
+
+		regex, err := regexp.Compile(pattern.Regex)
+		if err != nil {
+			newError := fmt.Errorf("invalid mask pattern %s: %w", pattern.Name, err)
+			return &newError
+		}
+
+		engine.patterns = append(engine.patterns, compiledPattern{
+			regex:     regex,
+			group:     regex.SubexpIndex("mask"),
+			keepFirst: pattern.KeepFirst,
+			keepLast:  pattern.KeepLast,
+			luhn:      pattern.Luhn,
+		})
+	}
+
+	masking = engine
+	return nil
+}
+
+// MaskText applies the configured regex patterns to s.
+func MaskText(s string) string {
+	if masking == nil {
+		return s
+	}
+
+	return masking.maskText(s)
+}
+
+// MaskBody masks the configured JSON paths of a JSON body and then applies
+// the regex patterns, so it is also safe for non-JSON bodies.
+func MaskBody(body string) string {
+	if masking == nil {
+		return body
+	}
+
+	return masking.maskText(masking.maskJson(body))
+}
+
+func MaskHeader(header map[string][]string) map[string][]string {
+	if masking == nil || header == nil {
+		return header
+	}
+
+	masked := make(map[string][]string, len(header))
+	for key, values := range header {
+		maskedValues := make([]string, len(values))
+		for i, value := range values {
+			maskedValues[i] = masking.header(key, value)
+		}
+		masked[key] = maskedValues
+	}
+
+	return masked
+}
+
+func MaskHeaderMap(header map[string]string) map[string]string {
+	if masking == nil || header == nil {
+		return header
+	}
+
+	masked := make(map[string]string, len(header))
+	for key, value := range header {
+		masked[key] = masking.header(key, value)
+	}
+
+	return masked
+}
+
+// maskHeaders masks a header map of any string keyed type, like the
+// headers of a client-party response, and keeps its type.
+func maskHeaders[T any](header T) T {
+	value := reflect.ValueOf(header)
+	if masking == nil || value.Kind() != reflect.Map || value.IsNil() || value.Type().Key().Kind() != reflect.String {
+		return header
+	}
+
+	masked := reflect.MakeMapWithSize(value.Type(), value.Len())
+	for _, key := range value.MapKeys() {
+		item := value.MapIndex(key)
+		switch {
+		case item.Kind() == reflect.String:
+			item = reflect.ValueOf(masking.header(key.String(), item.String())).Convert(item.Type())
+		case item.Kind() == reflect.Slice && item.Type().Elem().Kind() == reflect.String:
+			values := reflect.MakeSlice(item.Type(), item.Len(), item.Len())
+			for i := 0; i < item.Len(); i++ {
+				values.Index(i).SetString(masking.header(key.String(), item.Index(i).String()))
+			}
+			item = values
+		}
+		masked.SetMapIndex(key, item)
+	}
+
+	return masked.Interface().(T)
+}
+
+// maskData renders v for the zap data field, as JSON when masking is on
+// so JSON paths can be applied.
+func maskData(v any) string {
+	if masking == nil {
+		return fmt.Sprintf("%v", v)
+	}
+
+	return MaskBody(jsonMarshal(v))
+}
+
+// header masks the whole value of a configured header and applies the
+// regex patterns to the others.
+func (m *maskEngine) header(key string, value string) string {
+	if m.headers[strings.ToLower(key)] {
+		return m.partial(value, 0, 0)
+	}
+
+	return m.maskText(value)
+}
+
+func (m *maskEngine) maskText(s string) string {
+	for _, pattern := range m.patterns {
+		s = m.replace(s, pattern)
+	}
+
+	return s
+}
+
+func (m *maskEngine) replace(s string, pattern compiledPattern) string {
+	matches := pattern.regex.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+
+	var builder strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if pattern.group > 0 && match[2*pattern.group] >= 0 {
+			start, end = match[2*pattern.group], match[2*pattern.group+1]
+		}
+
+		if pattern.luhn && !luhnValid(s[start:end]) {
+			continue
+		}
+
+		builder.WriteString(s[last:start])
+		builder.WriteString(m.partial(s[start:end], pattern.keepFirst, pattern.keepLast))
+		last = end
+	}
+	builder.WriteString(s[last:])
+
+	return builder.String()
+}
+
+func (m *maskEngine) maskJson(body string) string {
+	if len(m.jsonPaths) == 0 {
+		return body
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+
+	var node any
+	if err := decoder.Decode(&node); err != nil {
+		return body
+	}
+
+	for _, jsonPath := range m.jsonPaths {
+		node = m.maskPath(node, jsonPath.parts, jsonPath)
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(node); err != nil {
+		return body
+	}
+
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+func (m *maskEngine) maskPath(node any, parts []string, jsonPath compiledJsonPath) any {
+	if len(parts) == 0 {
+		switch value := node.(type) {
+		case string:
+			return m.partial(value, jsonPath.keepFirst, jsonPath.keepLast)
+		case json.Number:
+			return m.partial(value.String(), jsonPath.keepFirst, jsonPath.keepLast)
+		case nil:
+			return nil
+		default:
+			return m.partial(jsonMarshal(value), 0, 0)
+		}
+	}
+
+	switch value := node.(type) {
+	case map[string]any:
+		for key, child := range value {
+			if parts[0] == "*" || parts[0] == key {
+				value[key] = m.maskPath(child, parts[1:], jsonPath)
+			}
+		}
+	case []any:
+		for i, child := range value {
+			if parts[0] == "*" || parts[0] == fmt.Sprintf("%d", i) {
+				value[i] = m.maskPath(child, parts[1:], jsonPath)
+			}
+		}
+	}
+
+	return node
+}
+
+// luhnValid reports whether the digits of value pass the Luhn check of
+// card numbers.
+func luhnValid(value string) bool {
+	sum := 0
+	double := false
+	for i := len(value) - 1; i >= 0; i-- {
+		digit := int(value[i] - '0')
+		if digit < 0 || digit > 9 {
+			return false
+		}
+
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+
+		sum += digit
+		double = !double
+	}
+
+	return sum%10 == 0
+}
+
+// partial keeps the first keepFirst and last keepLast characters of value,
+// e.g. 4111********1111, and masks all of it when it is too short.
+func (m *maskEngine) partial(value string, keepFirst int, keepLast int) string {
+	runes := []rune(value)
+	if keepFirst+keepLast >= len(runes) {
+		keepFirst, keepLast = 0, 0
+	}
+
+	masked := runes[:keepFirst:keepFirst]
+	masked = append(masked, []rune(strings.Repeat(m.maskChar, len(runes)-keepFirst-keepLast))...)
+	masked = append(masked, runes[len(runes)-keepLast:]...)
+
+	return string(masked)
+}
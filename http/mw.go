@@ -6,7 +6,6 @@ import (
 	"io"
 	"net/http"
 	"strconv"
-	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/gin-gonic/gin"
@@ -34,20 +33,46 @@ type IMw interface {
 	CorsPolicy(c *gin.Context)
 	DeliveryHandler(c *gin.Context)
 	MqttSubscribeHandler(msg mqtt.Message) int64
+	MqttSubscribeTrace(msg mqtt.Message) *Trace
+	Idempotency(model IdempotencyModel) gin.HandlerFunc
+	Recovery(c *gin.Context)
+	RateLimit(model RateLimitModel) (gin.HandlerFunc, *error)
+	Timeout(model TimeoutModel) gin.HandlerFunc
+	SnapVerify(model SnapVerifyModel) gin.HandlerFunc
+	SnapAccessToken(model SnapTokenModel) gin.HandlerFunc
+	SnapBearer(model SnapTokenModel, serviceCode string) gin.HandlerFunc
+	JwtAuth(model JwtModel) (IJwtAuth, *error)
 }
 
 func NewMw() IMw {
 	return mwContext{}
 }
 
+// CorsPolicy applies the CORS policy of OptConfig.Cors, or of the toml
+// config when none is set. OPTIONS requests are answered here.
 func (m mwContext) CorsPolicy(c *gin.Context) {
 
-	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, "+
-		"Authorization, accept, Origin, Cache-Control, X-Requested-With, Access-ID,"+
-		"Host, Connection, Pragma, Cache-Control, sec-ch-ua-mobile, User-Agent, sec-ch-ua, Sec-Fetch-Site, Sec-Fetch-Mode, Sec-Fetch-Dest, Referer")
-	c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
+	header := c.Writer.Header()
+	header.Add("Vary", "Origin")
+
+	origin := c.GetHeader("Origin")
+	preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	if origin != "" {
+		rule := getCors().rule(c.Request.URL.Path)
+		if !rule.isAllowed(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		} else {
+			rule.header(header, origin, preflight, c.GetHeader("Access-Control-Request-Headers"))
+		}
+	}
 
 	if c.Request.Method == http.MethodOptions {
 		c.AbortWithStatus(http.StatusNoContent)
@@ -59,36 +84,54 @@ func (m mwContext) CorsPolicy(c *gin.Context) {
 
 func (m mwContext) DeliveryHandler(c *gin.Context) {
 
-	responseId := time.Now().UnixNano()
+	responseId := NewResponseId()
+	trace := StartTrace(responseId)
 
-	UnixTimestamp[responseId] = responseId
-	Step[responseId] = 1
+	route := bodyLimitRoute(c)
+	if err := route.checkBody(c); err != nil {
+		abortBodyLimit(c, responseId, err)
+		return
+	}
 
-	rawData, errGetRawData := c.GetRawData()
-	duration := time.Now().UnixNano() - responseId
-	ms := duration / int64(time.Millisecond)
+	var rawData []byte
+	var errGetRawData error
+	if !route.Stream {
+		rawData, errGetRawData = c.GetRawData()
+	}
+	ms := trace.Duration()
+
+	if isBodyTooLarge(errGetRawData) {
+		abortBodyLimit(c, responseId, ErrBodyTooLarge)
+		return
+	}
 
 	_requestId := GetRequestIdFromRequest(rawData)
-	RequestId[responseId] = _requestId
+	trace.SetRequestID(_requestId)
+
+	logBody := truncateLogBody(MaskBody(string(rawData)))
+	if route.Stream {
+		logBody = "[stream]"
+	}
 
 	if infra.ZapLog != nil {
 		zapFields := []zapcore.Field{}
 		zapFields = append(zapFields, zap.Int("step", 1))
 
-		zapFields = append(zapFields, zap.String("duration", fmt.Sprintf("%v", ms)+" ms"))
-		zapFields = append(zapFields, zap.String("total-duration", fmt.Sprintf("%v", ms)+" ms"))
+		zapFields = append(zapFields, zap.String("duration", ms+" ms"))
+		zapFields = append(zapFields, zap.String("total-duration", ms+" ms"))
 		zapFields = append(zapFields, zap.String("client-ip", c.ClientIP()))
 		zapFields = append(zapFields, zap.String("http-method", c.Request.Method))
 		zapFields = append(zapFields, zap.String("url", c.Request.RequestURI))
-		zapFields = append(zapFields, zap.String("header", fmt.Sprintf("%v", c.Request.Header)))
+		zapFields = append(zapFields, zap.String("header", fmt.Sprintf("%v", MaskHeader(c.Request.Header))))
 
 		if errGetRawData != nil {
 			zapFields = append(zapFields, zap.String("error", errGetRawData.Error()))
 			infra.ZapLog.Warn(strconv.FormatInt(responseId, 10), zapFields...)
+			ReleaseTrace(responseId)
 			c.AbortWithStatusJSON(http.StatusInternalServerError, nil)
 			return
 		} else {
-			zapFields = append(zapFields, zap.String("request-body", string(rawData)))
+			zapFields = append(zapFields, zap.String("request-body", logBody))
 			infra.ZapLog.Debug(strconv.FormatInt(responseId, 10), zapFields...)
 		}
 
@@ -99,8 +142,8 @@ func (m mwContext) DeliveryHandler(c *gin.Context) {
 		logEntry := MwLogRequestData{
 			HttpMethod:    c.Request.Method,
 			URL:           c.Request.RequestURI,
-			RequestBody:   string(rawData),
-			RequestHeader: c.Request.Header,
+			RequestBody:   logBody,
+			RequestHeader: MaskHeader(c.Request.Header),
 		}
 
 		jsonString := string(jsonMarshal(logEntry))
@@ -113,43 +156,51 @@ func (m mwContext) DeliveryHandler(c *gin.Context) {
 			Message:      "Success",
 			FunctionName: getFunctionName(tracer.FunctionName),
 			Data:         jsonString,
-			Duration:     fmt.Sprintf("%v", ms) + " ms",
+			Duration:     ms + " ms",
 			Tracer:       tracer.FileName + ":" + strconv.Itoa(tracer.Line),
 			RequestID:    _requestId,
 		}
 
-		go func() {
-			_ = infra.GormDB.Debug().Create(&data)
-		}()
+		saveSqlLog(data)
 	}
 
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(rawData))
+	if !route.Stream {
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(rawData))
+	}
+	c.Request = c.Request.WithContext(WithTrace(c.Request.Context(), trace))
+	trace.SetContext(c.Request.Context())
 	c.Set("response-id", responseId)
+	c.Set(traceKey, trace)
 	c.Next()
+
+	// handlers answering without a Response, like c.JSON or
+	// c.AbortWithStatus, leave their trace behind
+	ReleaseTrace(responseId)
 }
 
 func (m mwContext) MqttSubscribeHandler(msg mqtt.Message) int64 {
-	responseId := time.Now().UnixNano()
+	return m.MqttSubscribeTrace(msg).ResponseID
+}
 
-	UnixTimestamp[responseId] = responseId
-	Step[responseId] = 1
+func (m mwContext) MqttSubscribeTrace(msg mqtt.Message) *Trace {
+	responseId := NewResponseId()
+	trace := StartTrace(responseId)
 
 	rawData := msg.Payload()
-	duration := time.Now().UnixNano() - responseId
-	ms := duration / int64(time.Millisecond)
+	ms := trace.Duration()
 
 	_requestId := GetRequestIdFromRequest(rawData)
-	RequestId[responseId] = _requestId
+	trace.SetRequestID(_requestId)
 
 	if infra.ZapLog != nil {
 		zapFields := []zapcore.Field{}
 		zapFields = append(zapFields, zap.Int("step", 1))
 
-		zapFields = append(zapFields, zap.String("duration", fmt.Sprintf("%v", ms)+" ms"))
-		zapFields = append(zapFields, zap.String("total-duration", fmt.Sprintf("%v", ms)+" ms"))
+		zapFields = append(zapFields, zap.String("duration", ms+" ms"))
+		zapFields = append(zapFields, zap.String("total-duration", ms+" ms"))
 		zapFields = append(zapFields, zap.String("mqtt-topic", msg.Topic()))
 
-		zapFields = append(zapFields, zap.String("mqtt-payload", string(rawData)))
+		zapFields = append(zapFields, zap.String("mqtt-payload", truncateLogBody(MaskBody(string(rawData)))))
 		infra.ZapLog.Debug(strconv.FormatInt(responseId, 10), zapFields...)
 
 	}
@@ -158,7 +209,7 @@ func (m mwContext) MqttSubscribeHandler(msg mqtt.Message) int64 {
 
 		logEntry := MwMqttRequestData{
 			Topic:   msg.Topic(),
-			Payload: string(rawData),
+			Payload: truncateLogBody(MaskBody(string(rawData))),
 		}
 
 		jsonString := string(jsonMarshal(logEntry))
@@ -171,15 +222,13 @@ func (m mwContext) MqttSubscribeHandler(msg mqtt.Message) int64 {
 			Message:      "Success",
 			FunctionName: getFunctionName(tracer.FunctionName),
 			Data:         jsonString,
-			Duration:     fmt.Sprintf("%v", ms) + " ms",
+			Duration:     ms + " ms",
 			Tracer:       tracer.FileName + ":" + strconv.Itoa(tracer.Line),
 			RequestID:    _requestId,
 		}
 
-		go func() {
-			_ = infra.GormDB.Debug().Create(&data)
-		}()
+		saveSqlLog(data)
 	}
 
-	return responseId
+	return trace
 }
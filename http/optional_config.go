@@ -9,8 +9,19 @@ import (
 )
 
 type OptConfigModel struct {
-	SqlLogs        bool
-	RequestIdAlias string
+	SqlLogs             bool
+	RequestIdAlias      string
+	NodeId              int64
+	ResponseIdGenerator IResponseIdGenerator
+	SqlLogWriter        SqlLogWriterModel
+	LogSinks            []LogSink
+	Masking             MaskModel
+	CircuitBreaker      CircuitBreakerModel
+	SnapServiceCode     string
+	SnapResponseCode    bool
+	Cors                CorsModel
+	Recovery            RecoveryModel
+	BodyLimit           BodyLimitModel
 }
 
 type sqlLog struct {
@@ -30,12 +41,48 @@ type sqlLog struct {
 
 func setOptionalConfig(config OptConfigModel) {
 
-	if infra.GormDB != nil && config.SqlLogs {
+	if infra.GormDB != nil && config.SqlLogs && useGormLogSink(config.LogSinks) {
 		if err := infra.GormDB.AutoMigrate(&sqlLog{}); err != nil {
 			fmt.Println("error db migrate", err)
 			os.Exit(1)
 		}
 	}
 
+	if config.SqlLogs {
+		if err := initSqlLogs(config.SqlLogWriter, config.LogSinks); err != nil {
+			fmt.Println("error sql log writer", *err)
+			os.Exit(1)
+		}
+	}
+
+	if err := setMasking(config.Masking); err != nil {
+		fmt.Println("error masking", *err)
+		os.Exit(1)
+	}
+
+	if err := setCors(config.Cors); err != nil {
+		fmt.Println("error cors", *err)
+		os.Exit(1)
+	}
+
+	if err := setResponseIdGenerator(config); err != nil {
+		fmt.Println("error response id generator", *err)
+		os.Exit(1)
+	}
+
 	OptConfig = config
 }
+
+func useGormLogSink(sinks []LogSink) bool {
+	if len(sinks) == 0 {
+		return true
+	}
+
+	for _, sink := range sinks {
+		if _, ok := sink.(gormLogSink); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,423 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/h4lim/og-kds/infra"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	RateLimitTokenBucket   = "token-bucket"
+	RateLimitSlidingWindow = "sliding-window"
+
+	RateLimitByPartner = "partner"
+	RateLimitByIP      = "ip"
+	RateLimitByRoute   = "route"
+)
+
+// tokenBucketScript refills the bucket from the time elapsed since its last
+// use, on the clock of the redis server, and takes one token when there is
+// one. It returns whether the token was taken and the tokens left.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], ttl)
+return {allowed, tostring(tokens)}
+`)
+
+// slidingWindowScript keeps the hits of the last window in a sorted set
+// scored by time. It returns whether the hit was counted, the hits in the
+// window and the milliseconds until the oldest one leaves it.
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[3])
+	count = count + 1
+	allowed = 1
+end
+redis.call("PEXPIRE", KEYS[1], window)
+local reset = 0
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, count, reset}
+`)
+
+// RateLimitModel allows Limit requests per Window for every key built from
+// KeyBy, or from KeyFunc when set. The token bucket holds Burst tokens,
+// Limit by default, refilled at Limit per Window. Window cannot be under
+// 1ms.
+//
+// RateLimitByPartner uses the caller verified by SnapVerify, SnapBearer or
+// JwtAuth when one of them ran before, and PartnerHeader otherwise. The header is
+// set by the client, so keying on it alone is only safe behind an
+// authentication that checks it.
+type RateLimitModel struct {
+	Name          string
+	Algorithm     string
+	Storage       string
+	Limit         int
+	Window        time.Duration
+	Burst         int
+	KeyBy         []string
+	KeyFunc       func(c *gin.Context) string
+	PartnerHeader string
+	HttpCode      int
+	Code          string
+	Snap          bool
+	ServiceCode   string
+}
+
+type rateLimitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+type localTokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+type localSlidingWindow struct {
+	mu   sync.Mutex
+	hits []time.Time
+}
+
+func (m RateLimitModel) withDefaults() RateLimitModel {
+	if m.Algorithm == "" {
+		m.Algorithm = RateLimitTokenBucket
+	}
+
+	if m.Storage == "" {
+		m.Storage = StorageCache
+	}
+
+	if m.Limit <= 0 {
+		m.Limit = 60
+	}
+
+	if m.Window <= 0 {
+		m.Window = time.Minute
+	}
+
+	if m.Burst <= 0 {
+		m.Burst = m.Limit
+	}
+
+	if len(m.KeyBy) == 0 {
+		m.KeyBy = []string{RateLimitByIP}
+	}
+
+	if m.PartnerHeader == "" {
+		m.PartnerHeader = "X-Partner-Id"
+	}
+
+	if m.HttpCode == 0 {
+		m.HttpCode = http.StatusTooManyRequests
+	}
+
+	return m
+}
+
+// validate rejects a Window under 1ms, the token bucket refills per
+// millisecond of it.
+func (m RateLimitModel) validate() *error {
+	if m.Window < time.Millisecond {
+		newError := fmt.Errorf("rate limit %s window %s is under 1ms", m.Name, m.Window)
+		return &newError
+	}
+
+	return nil
+}
+
+// RateLimit rejects the requests over the limit of model with its error
+// code in the standard envelope, setting Retry-After, and reports the
+// limit in the X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset headers. Requests pass when the storage fails. An
+// invalid model is reported as an error.
+func (m mwContext) RateLimit(model RateLimitModel) (gin.HandlerFunc, *error) {
+
+	model = model.withDefaults()
+	if err := model.validate(); err != nil {
+		return nil, err
+	}
+
+	return func(c *gin.Context) {
+		responseId, language := GetResponseIdAndLanguage(c)
+		key := "rate-limit:" + model.Name + ":" + rateLimitKey(c, model)
+
+		result, err := takeRateLimit(c.Request.Context(), model, key)
+		if err != nil {
+			warnRateLimit(responseId, key, *err)
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Set("X-RateLimit-Limit", strconv.Itoa(result.limit))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(result.remaining))
+		header.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+
+		if result.allowed {
+			c.Next()
+			return
+		}
+
+		header.Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.retryAfter), 1)))
+
+		response := InitResponse(responseId, language)
+		errLimit := errors.New("rate limit exceeded for " + key)
+		optData := OptSetR{
+			HttpCode:    model.HttpCode,
+			Code:        model.Code,
+			ServiceCode: model.ServiceCode,
+		}
+
+		if model.Snap && model.Code == "" {
+			optData.SnapCase = &SnapTooManyRequests
+		}
+
+		response.SetErrorR(&errLimit, Tracer(), optData)
+		abortWithResponse(c, response, model.Snap)
+	}, nil
+}
+
+func rateLimitKey(c *gin.Context, model RateLimitModel) string {
+	if model.KeyFunc != nil {
+		return model.KeyFunc(c)
+	}
+
+	parts := make([]string, 0, len(model.KeyBy))
+	for _, keyBy := range model.KeyBy {
+		switch keyBy {
+		case RateLimitByPartner:
+			partnerId := callerPartnerId(c, model.PartnerHeader)
+			if partnerId == "" {
+				partnerId = "ip-" + c.ClientIP()
+			}
+			parts = append(parts, partnerId)
+		case RateLimitByRoute:
+			route := c.FullPath()
+			if route == "" {
+				route = c.Request.URL.Path
+			}
+			parts = append(parts, c.Request.Method+" "+route)
+		default:
+			parts = append(parts, c.ClientIP())
+		}
+	}
+
+	return strings.Join(parts, ":")
+}
+
+func takeRateLimit(ctx context.Context, model RateLimitModel, key string) (rateLimitResult, *error) {
+
+	switch model.Storage {
+	case StorageRedis:
+		if infra.RedisDB == nil {
+			newError := errors.New("redis is not initialized")
+			return rateLimitResult{}, &newError
+		}
+
+		client, err := infra.RedisDB.Client()
+		if err != nil {
+			return rateLimitResult{}, err
+		}
+
+		if model.Algorithm == RateLimitSlidingWindow {
+			return redisSlidingWindow(ctx, client, model, key)
+		}
+
+		return redisTokenBucket(ctx, client, model, key)
+	default:
+		if infra.Cache == nil {
+			newError := errors.New("cache is not initialized")
+			return rateLimitResult{}, &newError
+		}
+
+		if model.Algorithm == RateLimitSlidingWindow {
+			return localSlidingWindowTake(model, key), nil
+		}
+
+		return localTokenBucketTake(model, key), nil
+	}
+}
+
+func redisTokenBucket(ctx context.Context, client *redis.Client, model RateLimitModel, key string) (rateLimitResult, *error) {
+	rate := float64(model.Limit) / float64(model.Window.Milliseconds())
+	ttl := int64(math.Ceil(float64(model.Burst)/rate)) + 1000
+
+	values, err := tokenBucketScript.Run(ctx, client, []string{key}, model.Burst, rate, ttl).Slice()
+	if err != nil {
+		return rateLimitResult{}, &err
+	}
+
+	allowed, _ := values[0].(int64)
+	tokens, _ := strconv.ParseFloat(values[1].(string), 64)
+
+	return tokenBucketResult(model, allowed == 1, tokens), nil
+}
+
+func redisSlidingWindow(ctx context.Context, client *redis.Client, model RateLimitModel, key string) (rateLimitResult, *error) {
+	values, err := slidingWindowScript.Run(ctx, client, []string{key},
+		model.Limit, model.Window.Milliseconds(), uuid.NewString()).Int64Slice()
+	if err != nil {
+		return rateLimitResult{}, &err
+	}
+
+	reset := time.Duration(values[2]) * time.Millisecond
+	result := rateLimitResult{
+		allowed:   values[0] == 1,
+		limit:     model.Limit,
+		remaining: max(model.Limit-int(values[1]), 0),
+		reset:     reset,
+	}
+
+	if !result.allowed {
+		result.retryAfter = reset
+	}
+
+	return result, nil
+}
+
+func localTokenBucketTake(model RateLimitModel, key string) rateLimitResult {
+	rate := float64(model.Limit) / model.Window.Seconds()
+	ttl := time.Duration(float64(model.Burst)/rate*float64(time.Second)) + time.Second
+
+	bucket := loadLocalLimiter(key, ttl, func() any {
+		return &localTokenBucket{tokens: float64(model.Burst), last: time.Now()}
+	}).(*localTokenBucket)
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	now := time.Now()
+	bucket.tokens = math.Min(float64(model.Burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+
+	return tokenBucketResult(model, allowed, bucket.tokens)
+}
+
+func localSlidingWindowTake(model RateLimitModel, key string) rateLimitResult {
+	window := loadLocalLimiter(key, model.Window, func() any {
+		return &localSlidingWindow{}
+	}).(*localSlidingWindow)
+
+	window.mu.Lock()
+	defer window.mu.Unlock()
+
+	now := time.Now()
+	expired := 0
+	for expired < len(window.hits) && now.Sub(window.hits[expired]) >= model.Window {
+		expired++
+	}
+	window.hits = window.hits[expired:]
+
+	result := rateLimitResult{limit: model.Limit}
+	if len(window.hits) < model.Limit {
+		window.hits = append(window.hits, now)
+		result.allowed = true
+	}
+
+	result.remaining = model.Limit - len(window.hits)
+	result.reset = window.hits[0].Add(model.Window).Sub(now)
+	if !result.allowed {
+		result.retryAfter = result.reset
+	}
+
+	return result
+}
+
+// loadLocalLimiter returns the limiter state of key in infra.Cache, creating
+// it when missing. The expiry is pushed back on every use so an active key
+// keeps its state.
+func loadLocalLimiter(key string, ttl time.Duration, create func() any) any {
+	value, found := infra.Cache.Get(key)
+	if !found {
+		created := create()
+		if err := infra.Cache.Add(key, created, ttl); err == nil {
+			return created
+		}
+
+		if value, found = infra.Cache.Get(key); !found {
+			return created
+		}
+	}
+
+	infra.Cache.Set(key, value, ttl)
+	return value
+}
+
+func tokenBucketResult(model RateLimitModel, allowed bool, tokens float64) rateLimitResult {
+	rate := float64(model.Limit) / model.Window.Seconds()
+
+	result := rateLimitResult{
+		allowed:   allowed,
+		limit:     model.Burst,
+		remaining: int(math.Floor(tokens)),
+		reset:     time.Duration((float64(model.Burst) - tokens) / rate * float64(time.Second)),
+	}
+
+	if !allowed {
+		result.retryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+
+	return result
+}
+
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
+
+func warnRateLimit(responseId int64, key string, err error) {
+	if infra.ZapLog != nil {
+		infra.ZapLog.Warn(strconv.FormatInt(responseId, 10),
+			zap.String("rate-limit-key", key),
+			zap.String("error", err.Error()))
+	}
+}
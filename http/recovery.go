@@ -0,0 +1,142 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h4lim/og-kds/infra"
+	"go.uber.org/zap"
+)
+
+type RecoveryModel struct {
+	Snap        bool
+	HttpCode    int
+	Code        string
+	ServiceCode string
+}
+
+// recoveredPanic carries a panic recovered on another goroutine, like the
+// handler goroutine of Timeout, together with its own stack.
+type recoveredPanic struct {
+	value  any
+	stack  []byte
+	tracer TracerModel
+}
+
+type recoveryData struct {
+	Panic string `json:"panic"`
+	Stack string `json:"stack"`
+}
+
+// Recovery turns a panic further down the chain into the standard error
+// envelope of OptConfig.Recovery, logging the panic and its stack as a step
+// of the request. It has to run before DeliveryHandler.
+func (m mwContext) Recovery(c *gin.Context) {
+
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		stack := debug.Stack()
+		tracer := panicTracer()
+		if panicked, ok := recovered.(recoveredPanic); ok {
+			recovered, stack, tracer = panicked.value, panicked.stack, panicked.tracer
+		}
+
+		if recovered == http.ErrAbortHandler {
+			panic(recovered)
+		}
+
+		model := OptConfig.Recovery
+		if model.HttpCode == 0 {
+			model.HttpCode = http.StatusInternalServerError
+		}
+
+		responseId, language := GetResponseIdAndLanguage(c)
+		response := InitResponse(responseId, language)
+
+		errPanic := fmt.Errorf("panic recovered: %v", recovered)
+		optData := OptSetR{
+			HttpCode:    model.HttpCode,
+			Code:        model.Code,
+			ServiceCode: model.ServiceCode,
+			Data: recoveryData{
+				Panic: fmt.Sprintf("%v", recovered),
+				Stack: string(stack),
+			},
+		}
+
+		if model.Snap && model.Code == "" {
+			optData.SnapCase = &SnapInternalServerError
+		}
+
+		response.SetErrorR(&errPanic, tracer, optData)
+
+		if isBrokenPipe(recovered) || c.Writer.Written() {
+			response.BuildVoidResponse()
+			c.Abort()
+			return
+		}
+
+		abortWithResponse(c, response, model.Snap)
+	}()
+
+	c.Next()
+}
+
+// panicTracer points at the function that panicked, the first frame after
+// runtime.gopanic.
+func panicTracer() TracerModel {
+	var model TracerModel
+
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+
+	panicking := false
+	for {
+		frame, more := frames.Next()
+		if panicking && !strings.HasPrefix(frame.Function, "runtime.") {
+			model.FunctionName = frame.Function
+			model.FileName = frame.File
+			model.Line = frame.Line
+			return model
+		}
+
+		if frame.Function == "runtime.gopanic" {
+			panicking = true
+		}
+
+		if !more {
+			return Tracer()
+		}
+	}
+}
+
+func isBrokenPipe(recovered any) bool {
+	err, ok := recovered.(error)
+	if !ok {
+		return false
+	}
+
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// warnLatePanic logs a panic of a handler abandoned by Timeout, after the
+// timeout response was sent.
+func warnLatePanic(responseId int64, panicked *recoveredPanic) {
+	if infra.ZapLog != nil {
+		infra.ZapLog.Warn(strconv.FormatInt(responseId, 10),
+			zap.String("panic", fmt.Sprintf("%v", panicked.value)),
+			zap.String("stack", string(panicked.stack)),
+			zap.String("trace", panicked.tracer.FileName+":"+strconv.Itoa(panicked.tracer.Line)))
+	}
+}
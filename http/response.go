@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/h4lim/og-kds/infra"
 
@@ -62,14 +61,17 @@ type Response struct {
 	Tracer           TracerModel
 	ResponseID       int64
 	Language         string
+	ServiceCode      string
 	AdditionalTracer []string
 }
 
 type OptSetR struct {
-	HttpCode int
-	Code     string
-	Message  string
-	Data     any
+	HttpCode    int
+	Code        string
+	Message     string
+	Data        any
+	SnapCase    *SnapCase
+	ServiceCode string
 }
 
 func InitResponse(responseID int64, language string) Response {
@@ -87,6 +89,10 @@ func (r *Response) SetSuccessR(Tracer TracerModel, optData ...OptSetR) Response
 		_optData = optData[len(optData)-1]
 	}
 
+	if _optData.SnapCase != nil {
+		r.setSnapCase(&_optData)
+	}
+
 	if _optData.HttpCode == 0 {
 		_optData.HttpCode = 200
 	}
@@ -122,6 +128,10 @@ func (r *Response) SetErrorR(Error *error, Tracer TracerModel, optData ...OptSet
 		_optData = optData[len(optData)-1]
 	}
 
+	if _optData.SnapCase != nil {
+		r.setSnapCase(&_optData)
+	}
+
 	if _optData.HttpCode == 0 {
 		_optData.HttpCode = 400
 	}
@@ -176,6 +186,9 @@ func (r *Response) SetAll(newR Response) Response {
 	r.Data = newR.Data
 	r.Error = newR.Error
 	r.Tracer = newR.Tracer
+	if newR.ServiceCode != "" {
+		r.ServiceCode = newR.ServiceCode
+	}
 
 	if r.Message == "" {
 		r.getMessage()
@@ -240,9 +253,7 @@ func (r Response) BuildGinResponse() (int, any) {
 		r.logSql()
 	}
 
-	delete(UnixTimestamp, r.ResponseID)
-	delete(Step, r.ResponseID)
-	delete(RequestId, r.ResponseID)
+	ReleaseTrace(r.ResponseID)
 
 	return r.HttpCode, RequestBuildGin{
 		Code:       r.Code,
@@ -260,9 +271,7 @@ func (r Response) BuildGinResponseWithData(data any) (int, any) {
 		r.logSql()
 	}
 
-	delete(UnixTimestamp, r.ResponseID)
-	delete(Step, r.ResponseID)
-	delete(RequestId, r.ResponseID)
+	ReleaseTrace(r.ResponseID)
 
 	return r.HttpCode, RequestBuildGinWithData{
 		Code:       r.Code,
@@ -274,15 +283,14 @@ func (r Response) BuildGinResponseWithData(data any) (int, any) {
 
 func (r Response) BuildGinResponseSnap() (int, any) {
 
+	r = r.snapResponse()
 	r.Tracer.FunctionName = "api.finalResponse"
 	r.debug(true)
 	if OptConfig.SqlLogs {
 		r.logSql()
 	}
 
-	delete(UnixTimestamp, r.ResponseID)
-	delete(Step, r.ResponseID)
-	delete(RequestId, r.ResponseID)
+	ReleaseTrace(r.ResponseID)
 
 	return r.HttpCode, RequestBuildGinSnap{
 		ResponseCode:    r.Code,
@@ -293,6 +301,7 @@ func (r Response) BuildGinResponseSnap() (int, any) {
 
 func (r Response) BuildGinResponseSnapWithData(data any) (int, any) {
 
+	r = r.snapResponse()
 	r.Data = data
 	r.Tracer.FunctionName = "api.finalResponse"
 	r.debug(true)
@@ -300,9 +309,7 @@ func (r Response) BuildGinResponseSnapWithData(data any) (int, any) {
 		r.logSql()
 	}
 
-	delete(UnixTimestamp, r.ResponseID)
-	delete(Step, r.ResponseID)
-	delete(RequestId, r.ResponseID)
+	ReleaseTrace(r.ResponseID)
 
 	return r.HttpCode, RequestBuildGinSnapWithData{
 		ResponseCode:    r.Code,
@@ -320,9 +327,7 @@ func (r Response) BuildVoidResponse() {
 		r.logSql()
 	}
 
-	delete(UnixTimestamp, r.ResponseID)
-	delete(Step, r.ResponseID)
-	delete(RequestId, r.ResponseID)
+	ReleaseTrace(r.ResponseID)
 }
 
 func (r *Response) IsError() bool {
@@ -333,8 +338,6 @@ func (r *Response) debug(nextStep bool) {
 
 	if infra.ZapLog != nil {
 
-		duration := time.Now().UnixNano() - r.ResponseID
-		ms := float64(duration) / float64(time.Millisecond)
 		zapFields := []zapcore.Field{}
 
 		if nextStep {
@@ -344,12 +347,12 @@ func (r *Response) debug(nextStep bool) {
 		}
 
 		zapFields = append(zapFields, zap.String("duration", GetDuration(r.ResponseID)+" ms"))
-		zapFields = append(zapFields, zap.String("total-duration", fmt.Sprintf("%v", ms)+" ms"))
+		zapFields = append(zapFields, zap.String("total-duration", GetTotalDuration(r.ResponseID)+" ms"))
 		zapFields = append(zapFields, zap.String("additional-tracer", strings.Join(r.AdditionalTracer, " ")))
 		zapFields = append(zapFields, zap.Int("http-code", r.HttpCode))
 		zapFields = append(zapFields, zap.String("code", r.Code))
 		zapFields = append(zapFields, zap.String("message ", r.Message))
-		zapFields = append(zapFields, zap.String("data", fmt.Sprintf("%v", r.Data)))
+		zapFields = append(zapFields, zap.String("data", maskData(r.Data)))
 		zapFields = append(zapFields, zap.String("filename", r.Tracer.FileName))
 		zapFields = append(zapFields, zap.String("function-name", r.Tracer.FunctionName))
 		zapFields = append(zapFields, zap.Int("line", r.Tracer.Line))
@@ -398,7 +401,7 @@ func (r *Response) logSql() {
 	if err != nil {
 		_data = fmt.Sprintf("%v", r.Data)
 	} else {
-		_data = string(jsonData)
+		_data = MaskBody(string(jsonData))
 	}
 
 	data := sqlLog{
@@ -413,7 +416,5 @@ func (r *Response) logSql() {
 		RequestID:    _requestId,
 	}
 
-	go func() {
-		_ = infra.GormDB.Debug().Create(&data)
-	}()
+	saveSqlLog(data)
 }
@@ -0,0 +1,202 @@
+package http
+
+import (
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Response ids are laid out as 41 bits of milliseconds since idEpoch,
+// 10 bits of node id and 12 bits of per-millisecond sequence, so they stay
+// positive int64 values that sort by creation time.
+const (
+	nodeIdBits   = 10
+	sequenceBits = 12
+	maxNodeId    = -1 ^ (-1 << nodeIdBits)
+	maxSequence  = -1 ^ (-1 << sequenceBits)
+)
+
+var idEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var responseIdGenerator IResponseIdGenerator = snowflakeGenerator{state: &snowflakeState{}}
+
+type IResponseIdGenerator interface {
+	NextId() int64
+}
+
+// ResponseIdGeneratorFunc adapts a plain function to IResponseIdGenerator.
+type ResponseIdGeneratorFunc func() int64
+
+func (f ResponseIdGeneratorFunc) NextId() int64 {
+	return f()
+}
+
+type snowflakeState struct {
+	mu       sync.Mutex
+	lastMs   int64
+	sequence int64
+}
+
+type snowflakeGenerator struct {
+	nodeId int64
+	state  *snowflakeState
+}
+
+type uuidV7Generator struct {
+	nodeId   int64
+	fallback snowflakeGenerator
+}
+
+// ulidState holds the millisecond and the 80 bits of entropy of the last
+// ULID, incremented within the same millisecond as monotonic ULIDs are.
+type ulidState struct {
+	mu      sync.Mutex
+	lastMs  int64
+	entropy [10]byte
+	issued  int64
+}
+
+type ulidGenerator struct {
+	nodeId int64
+	state  *ulidState
+}
+
+func NewSnowflakeGenerator(nodeId int64) (IResponseIdGenerator, *error) {
+	if err := validateNodeId(nodeId); err != nil {
+		return nil, err
+	}
+
+	return snowflakeGenerator{
+		nodeId: nodeId,
+		state:  &snowflakeState{},
+	}, nil
+}
+
+func NewUUIDv7Generator(nodeId int64) (IResponseIdGenerator, *error) {
+	if err := validateNodeId(nodeId); err != nil {
+		return nil, err
+	}
+
+	return uuidV7Generator{
+		nodeId:   nodeId,
+		fallback: snowflakeGenerator{nodeId: nodeId, state: &snowflakeState{}},
+	}, nil
+}
+
+func NewULIDGenerator(nodeId int64) (IResponseIdGenerator, *error) {
+	if err := validateNodeId(nodeId); err != nil {
+		return nil, err
+	}
+
+	return ulidGenerator{
+		nodeId: nodeId,
+		state:  &ulidState{},
+	}, nil
+}
+
+func NewResponseId() int64 {
+	return responseIdGenerator.NextId()
+}
+
+// NextId implements IResponseIdGenerator. When the sequence of the current
+// millisecond is exhausted, or the wall clock moves backwards, it keeps
+// counting from the last issued millisecond so ids never repeat.
+func (s snowflakeGenerator) NextId() int64 {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	now := time.Since(idEpoch).Milliseconds()
+	if now <= s.state.lastMs {
+		s.state.sequence = (s.state.sequence + 1) & maxSequence
+		now = s.state.lastMs
+		if s.state.sequence == 0 {
+			now++
+		}
+	} else {
+		s.state.sequence = 0
+	}
+
+	s.state.lastMs = now
+
+	return now<<(nodeIdBits+sequenceBits) | s.nodeId<<sequenceBits | s.state.sequence
+}
+
+// NextId implements IResponseIdGenerator. It keeps the millisecond timestamp
+// and the monotonic 12 bit sequence of a version 7 UUID.
+func (u uuidV7Generator) NextId() int64 {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return u.fallback.NextId()
+	}
+
+	var unixMs int64
+	for _, b := range id[:6] {
+		unixMs = unixMs<<8 | int64(b)
+	}
+	sequence := int64(id[6]&0x0f)<<8 | int64(id[7])
+	ms := unixMs - idEpoch.UnixMilli()
+
+	return ms<<(nodeIdBits+sequenceBits) | u.nodeId<<sequenceBits | sequence
+}
+
+// NextId implements IResponseIdGenerator. It keeps the millisecond timestamp
+// of a monotonic ULID and the low 12 bits of its entropy. Once 4096 ids were
+// issued in one millisecond it moves on to the next one so ids never repeat.
+func (u ulidGenerator) NextId() int64 {
+	u.state.mu.Lock()
+	defer u.state.mu.Unlock()
+
+	now := time.Since(idEpoch).Milliseconds()
+	if now <= u.state.lastMs && u.state.issued < maxSequence {
+		now = u.state.lastMs
+		u.state.issued++
+		for i := len(u.state.entropy) - 1; i >= 0; i-- {
+			u.state.entropy[i]++
+			if u.state.entropy[i] != 0 {
+				break
+			}
+		}
+	} else {
+		if now <= u.state.lastMs {
+			now = u.state.lastMs + 1
+		}
+
+		u.state.issued = 0
+		if _, err := rand.Read(u.state.entropy[:]); err != nil {
+			clear(u.state.entropy[:])
+		}
+	}
+
+	u.state.lastMs = now
+	sequence := int64(u.state.entropy[8]&0x0f)<<8 | int64(u.state.entropy[9])
+
+	return now<<(nodeIdBits+sequenceBits) | u.nodeId<<sequenceBits | sequence
+}
+
+func setResponseIdGenerator(config OptConfigModel) *error {
+	if config.ResponseIdGenerator != nil {
+		responseIdGenerator = config.ResponseIdGenerator
+		return nil
+	}
+
+	generator, err := NewSnowflakeGenerator(config.NodeId)
+	if err != nil {
+		return err
+	}
+
+	responseIdGenerator = generator
+	return nil
+}
+
+func validateNodeId(nodeId int64) *error {
+	if nodeId < 0 || nodeId > maxNodeId {
+		newError := errors.New("node id must be between 0 and " + strconv.Itoa(maxNodeId))
+		return &newError
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	party "github.com/h4lim/client-party"
+)
+
+// RetryPolicy only retries idempotent methods unless RetryNonIdempotent is
+// set, e.g. for a POST guarded by an X-EXTERNAL-ID the partner deduplicates.
+// RetryOnTimeout only applies to transports that abort the request on
+// timeout; an abandoned client-party call is never retried.
+type RetryPolicy struct {
+	MaxAttempts        int
+	BackoffBase        time.Duration
+	BackoffCap         time.Duration
+	Jitter             bool
+	RetryStatusCodes   []int
+	RetryOnTimeout     bool
+	RetryNonIdempotent bool
+	HonorRetryAfter    bool
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.BackoffBase <= 0 {
+		p.BackoffBase = 100 * time.Millisecond
+	}
+
+	if p.BackoffCap <= 0 {
+		p.BackoffCap = 5 * time.Second
+	}
+
+	if p.RetryStatusCodes == nil {
+		p.RetryStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+
+	return p
+}
+
+// shouldRetry decides whether the attempt that produced c is followed by
+// another one and how long to wait before it.
+func (p RetryPolicy) shouldRetry(attempt int, c ClientContext) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	if !p.RetryNonIdempotent && !isIdempotentMethod(c.ClientRequest.HttpMethod) {
+		return 0, false
+	}
+
+	if c.Error != nil {
+		if errors.Is(c.Error, ErrCircuitOpen) || errors.Is(c.Error, ErrClientCanceled) ||
+			errors.Is(c.Error, ErrClientAbandoned) {
+			return 0, false
+		}
+
+		if isTimeout(c.Error) && !p.RetryOnTimeout {
+			return 0, false
+		}
+
+		return p.backoff(attempt), true
+	}
+
+	if c.PartyResponse == nil || !p.isRetryStatus(c.PartyResponse.HttpCode) {
+		return 0, false
+	}
+
+	if p.HonorRetryAfter {
+		if retryAfter, ok := parseRetryAfter(responseHeaderValue(c.PartyResponse, "Retry-After")); ok {
+			return min(retryAfter, p.BackoffCap), true
+		}
+	}
+
+	return p.backoff(attempt), true
+}
+
+// backoff is BackoffBase doubled per attempt up to BackoffCap, with full
+// jitter when enabled.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BackoffCap
+	if attempt < 32 {
+		delay = min(p.BackoffBase<<(attempt-1), p.BackoffCap)
+	}
+
+	if p.Jitter && delay > 0 {
+		delay = time.Duration(rand.Int63n(int64(delay) + 1))
+	}
+
+	return delay
+}
+
+func (p RetryPolicy) isRetryStatus(httpCode int) bool {
+	for _, code := range p.RetryStatusCodes {
+		if code == httpCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isIdempotentMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+
+	var netError net.Error
+	return errors.As(err, &netError) && netError.Timeout()
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+
+	return 0, false
+}
+
+// responseHeaderValue reads a header from the client-party response without
+// depending on the concrete map type it uses for headers.
+func responseHeaderValue(response *party.Response, key string) string {
+	switch header := any(response.ResponseHeader).(type) {
+	case http.Header:
+		return header.Get(key)
+	case map[string][]string:
+		return http.Header(header).Get(key)
+	case map[string]string:
+		for k, v := range header {
+			if strings.EqualFold(k, key) {
+				return v
+			}
+		}
+	}
+
+	value := reflect.ValueOf(response.ResponseHeader)
+	if value.Kind() != reflect.Map || value.Type().Key().Kind() != reflect.String {
+		return ""
+	}
+
+	for _, k := range value.MapKeys() {
+		if !strings.EqualFold(k.String(), key) {
+			continue
+		}
+
+		v := value.MapIndex(k)
+		switch v.Kind() {
+		case reflect.String:
+			return v.String()
+		case reflect.Slice:
+			if v.Len() > 0 && v.Index(0).Kind() == reflect.String {
+				return v.Index(0).String()
+			}
+		}
+	}
+
+	return ""
+}
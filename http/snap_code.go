@@ -0,0 +1,185 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/h4lim/og-kds/infra"
+)
+
+// SnapCase is one of the standard SNAP BI response cases. The responseCode
+// of a case is its http status, a 2 digit service code and its 2 digit case
+// code, e.g. 4011800 for SnapUnauthorized on service 18.
+type SnapCase struct {
+	HttpCode int
+	CaseCode string
+	Message  string
+}
+
+var (
+	SnapSuccessful     = SnapCase{http.StatusOK, "00", "Successful"}
+	SnapInProgress     = SnapCase{http.StatusAccepted, "00", "Request In Progress"}
+	SnapBadRequest     = SnapCase{http.StatusBadRequest, "00", "Bad Request"}
+	SnapInvalidFormat  = SnapCase{http.StatusBadRequest, "01", "Invalid Field Format"}
+	SnapMandatoryField = SnapCase{http.StatusBadRequest, "02", "Invalid Mandatory Field"}
+
+	SnapUnauthorized          = SnapCase{http.StatusUnauthorized, "00", "Unauthorized"}
+	SnapInvalidToken          = SnapCase{http.StatusUnauthorized, "01", "Invalid Token (B2B)"}
+	SnapInvalidCustomerToken  = SnapCase{http.StatusUnauthorized, "02", "Invalid Customer Token"}
+	SnapTokenNotFound         = SnapCase{http.StatusUnauthorized, "03", "Token Not Found (B2B)"}
+	SnapCustomerTokenNotFound = SnapCase{http.StatusUnauthorized, "04", "Customer Token Not Found"}
+
+	SnapTransactionExpired    = SnapCase{http.StatusForbidden, "00", "Transaction Expired"}
+	SnapFeatureNotAllowed     = SnapCase{http.StatusForbidden, "01", "Feature Not Allowed"}
+	SnapExceedsAmountLimit    = SnapCase{http.StatusForbidden, "02", "Exceeds Transaction Amount Limit"}
+	SnapSuspectedFraud        = SnapCase{http.StatusForbidden, "03", "Suspected Fraud"}
+	SnapActivityLimitExceeded = SnapCase{http.StatusForbidden, "04", "Activity Count Limit Exceeded"}
+	SnapDoNotHonor            = SnapCase{http.StatusForbidden, "05", "Do Not Honor"}
+	SnapCardBlocked           = SnapCase{http.StatusForbidden, "07", "Card Blocked"}
+	SnapCardExpired           = SnapCase{http.StatusForbidden, "08", "Card Expired"}
+	SnapDormantAccount        = SnapCase{http.StatusForbidden, "09", "Dormant Account"}
+	SnapInsufficientFunds     = SnapCase{http.StatusForbidden, "14", "Insufficient Funds"}
+	SnapTransactionForbidden  = SnapCase{http.StatusForbidden, "15", "Transaction Not Permitted"}
+	SnapInactiveAccount       = SnapCase{http.StatusForbidden, "18", "Inactive Card/Account/Customer"}
+
+	SnapInvalidTransactionStatus = SnapCase{http.StatusNotFound, "00", "Invalid Transaction Status"}
+	SnapTransactionNotFound      = SnapCase{http.StatusNotFound, "01", "Transaction Not Found"}
+	SnapInvalidRouting           = SnapCase{http.StatusNotFound, "02", "Invalid Routing"}
+	SnapBankNotSupported         = SnapCase{http.StatusNotFound, "03", "Bank Not Supported By Switch"}
+	SnapTransactionCancelled     = SnapCase{http.StatusNotFound, "04", "Transaction Cancelled"}
+	SnapInvalidMerchant          = SnapCase{http.StatusNotFound, "08", "Invalid Merchant"}
+	SnapInvalidAccount           = SnapCase{http.StatusNotFound, "11", "Invalid Card/Account/Customer/Virtual Account"}
+	SnapInvalidBill              = SnapCase{http.StatusNotFound, "12", "Invalid Bill/Virtual Account"}
+	SnapInvalidAmount            = SnapCase{http.StatusNotFound, "13", "Invalid Amount"}
+	SnapPaidBill                 = SnapCase{http.StatusNotFound, "14", "Paid Bill"}
+	SnapPartnerNotFound          = SnapCase{http.StatusNotFound, "16", "Partner Not Found"}
+	SnapInconsistentRequest      = SnapCase{http.StatusNotFound, "18", "Inconsistent Request"}
+
+	SnapFunctionNotSupported = SnapCase{http.StatusMethodNotAllowed, "00", "Requested Function Is Not Supported"}
+	SnapOperationNotAllowed  = SnapCase{http.StatusMethodNotAllowed, "01", "Requested Operation Is Not Allowed"}
+
+	SnapConflict                  = SnapCase{http.StatusConflict, "00", "Conflict"}
+	SnapDuplicatePartnerReference = SnapCase{http.StatusConflict, "01", "Duplicate partnerReferenceNo"}
+	SnapTooManyRequests           = SnapCase{http.StatusTooManyRequests, "00", "Too Many Requests"}
+	SnapGeneralError              = SnapCase{http.StatusInternalServerError, "00", "General Error"}
+	SnapInternalServerError       = SnapCase{http.StatusInternalServerError, "01", "Internal Server Error"}
+	SnapExternalServerError       = SnapCase{http.StatusInternalServerError, "02", "External Server Error"}
+	SnapTimeout                   = SnapCase{http.StatusGatewayTimeout, "00", "Timeout"}
+)
+
+// Code is the 7 digit responseCode of the case for a service code.
+func (s SnapCase) Code(serviceCode string) string {
+	return strconv.Itoa(s.HttpCode) + snapServiceCode(serviceCode) + s.CaseCode
+}
+
+// GetMessage looks the case up in infra.MessageID or infra.MessageEN, first
+// by its full code and then by its generic key, e.g. 401SS00, before falling
+// back to the standard message.
+func (s SnapCase) GetMessage(serviceCode string, language string) string {
+	messages := infra.MessageEN
+	if strings.ToUpper(language) == "ID" {
+		messages = infra.MessageID
+	}
+
+	if message := messages[s.Code(serviceCode)]; message != "" {
+		return message
+	}
+
+	if message := messages[strconv.Itoa(s.HttpCode)+"SS"+s.CaseCode]; message != "" {
+		return message
+	}
+
+	return s.Message
+}
+
+// snapCaseForHttpCode is the generic case used when a response built for
+// SNAP does not carry a SNAP responseCode.
+func snapCaseForHttpCode(httpCode int) SnapCase {
+	switch {
+	case httpCode == http.StatusAccepted:
+		return SnapInProgress
+	case httpCode >= 200 && httpCode < 300:
+		return SnapSuccessful
+	case httpCode == http.StatusUnauthorized:
+		return SnapUnauthorized
+	case httpCode == http.StatusForbidden:
+		return SnapFeatureNotAllowed
+	case httpCode == http.StatusNotFound:
+		return SnapTransactionNotFound
+	case httpCode == http.StatusMethodNotAllowed:
+		return SnapFunctionNotSupported
+	case httpCode == http.StatusConflict:
+		return SnapConflict
+	case httpCode == http.StatusTooManyRequests:
+		return SnapTooManyRequests
+	case httpCode == http.StatusGatewayTimeout:
+		return SnapTimeout
+	case httpCode == http.StatusBadRequest:
+		return SnapBadRequest
+	case httpCode == http.StatusInternalServerError:
+		return SnapInternalServerError
+	default:
+		return SnapCase{httpCode, "00", http.StatusText(httpCode)}
+	}
+}
+
+func isSnapCode(code string, httpCode int) bool {
+	if len(code) != 7 || !strings.HasPrefix(code, strconv.Itoa(httpCode)) {
+		return false
+	}
+
+	_, err := strconv.Atoi(code)
+	return err == nil
+}
+
+func snapServiceCode(serviceCode string) string {
+	if serviceCode == "" {
+		serviceCode = OptConfig.SnapServiceCode
+	}
+
+	if serviceCode == "" {
+		return "00"
+	}
+
+	return serviceCode
+}
+
+// setSnapCase fills the http code, code and, when not given, the message of
+// optData from its SnapCase.
+func (r *Response) setSnapCase(optData *OptSetR) {
+	snapCase := *optData.SnapCase
+
+	r.ServiceCode = snapServiceCode(optData.ServiceCode)
+	optData.HttpCode = snapCase.HttpCode
+	optData.Code = snapCase.Code(r.ServiceCode)
+
+	if optData.Message == "" {
+		optData.Message = snapCase.GetMessage(r.ServiceCode, r.Language)
+	}
+}
+
+// snapResponse derives the SNAP responseCode from the http code when the
+// response was set with a non SNAP code, like the "99" default of
+// SetErrorR. It only does so when OptConfig.SnapResponseCode is set,
+// otherwise the code is kept as given. The message is replaced as well
+// unless it was given explicitly.
+func (r Response) snapResponse() Response {
+	if !OptConfig.SnapResponseCode || isSnapCode(r.Code, r.HttpCode) {
+		return r
+	}
+
+	previous := r
+	previous.getMessage()
+
+	snapCase := snapCaseForHttpCode(r.HttpCode)
+	r.ServiceCode = snapServiceCode(r.ServiceCode)
+	r.HttpCode = snapCase.HttpCode
+	r.Code = snapCase.Code(r.ServiceCode)
+
+	if r.Message == "" || r.Message == previous.Message {
+		r.Message = snapCase.GetMessage(r.ServiceCode, r.Language)
+	}
+
+	return r
+}
@@ -0,0 +1,356 @@
+package http
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SnapSignatureAccessToken = "access-token"
+	SnapSignatureSymmetric   = "symmetric"
+	SnapSignatureAsymmetric  = "asymmetric"
+
+	SnapTimestampLayout = "2006-01-02T15:04:05-07:00"
+)
+
+var rsaKeys sync.Map
+
+// SnapSignModel signs an outbound request per the SNAP BI spec:
+// SnapSignatureAccessToken for /access-token/b2b (RSA-SHA256 over
+// client key and timestamp), SnapSignatureSymmetric for transactions
+// (HMAC-SHA512 with the client secret) and SnapSignatureAsymmetric for
+// transactions signed with the private key.
+type SnapSignModel struct {
+	Type           string
+	ClientKey      string
+	ClientSecret   string
+	PrivateKeyFile string
+	PrivateKey     *rsa.PrivateKey
+	AccessToken    string
+	PartnerId      string
+	ExternalId     string
+	ChannelId      string
+}
+
+// SnapStringToSign builds the transaction string to sign,
+// METHOD:EndpointUrl[:AccessToken]:hex(sha256(minify(body))):Timestamp. The
+// access token part is left out when accessToken is empty, as the
+// asymmetric transaction signature does.
+func SnapStringToSign(method string, endpointUrl string, accessToken string, body string, timestamp string) (string, error) {
+	bodyHash, err := snapBodyHash(body)
+	if err != nil {
+		return "", err
+	}
+
+	parts := []string{strings.ToUpper(method), endpointUrl}
+	if accessToken != "" {
+		parts = append(parts, accessToken)
+	}
+	parts = append(parts, bodyHash, timestamp)
+
+	return strings.Join(parts, ":"), nil
+}
+
+func SnapAccessTokenSignature(privateKey *rsa.PrivateKey, clientKey string, timestamp string) (string, error) {
+	return signRsaSha256(privateKey, clientKey+"|"+timestamp)
+}
+
+func SnapSymmetricSignature(clientSecret string, stringToSign string) string {
+	mac := hmac.New(sha512.New, []byte(clientSecret))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func SnapAsymmetricSignature(privateKey *rsa.PrivateKey, stringToSign string) (string, error) {
+	return signRsaSha256(privateKey, stringToSign)
+}
+
+// LoadRsaPrivateKey reads a PKCS#1 or PKCS#8 PEM private key. Keys are
+// cached per path.
+func LoadRsaPrivateKey(path string) (*rsa.PrivateKey, *error) {
+	if key, ok := rsaKeys.Load("private:" + path); ok {
+		return key.(*rsa.PrivateKey), nil
+	}
+
+	block, err := readPem(path)
+	if err != nil {
+		return nil, &err
+	}
+
+	var privateKey *rsa.PrivateKey
+	if key, errParse := x509.ParsePKCS1PrivateKey(block.Bytes); errParse == nil {
+		privateKey = key
+	} else {
+		key, errParse := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if errParse != nil {
+			return nil, &errParse
+		}
+
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			newError := errors.New("private key in " + path + " is not an rsa key")
+			return nil, &newError
+		}
+		privateKey = rsaKey
+	}
+
+	rsaKeys.Store("private:"+path, privateKey)
+	return privateKey, nil
+}
+
+// LoadRsaPublicKey reads a PKIX or PKCS#1 PEM public key, or the key of a
+// PEM certificate. Keys are cached per path.
+func LoadRsaPublicKey(path string) (*rsa.PublicKey, *error) {
+	if key, ok := rsaKeys.Load("public:" + path); ok {
+		return key.(*rsa.PublicKey), nil
+	}
+
+	block, err := readPem(path)
+	if err != nil {
+		return nil, &err
+	}
+
+	publicKey, err := ParseRsaPublicKey(block)
+	if err != nil {
+		return nil, &err
+	}
+
+	rsaKeys.Store("public:"+path, publicKey)
+	return publicKey, nil
+}
+
+func ParseRsaPublicKey(block *pem.Block) (*rsa.PublicKey, error) {
+	key, err := parsePemPublicKey(block)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an rsa key")
+	}
+
+	return publicKey, nil
+}
+
+// signSnap returns a copy of the request with the SNAP signature and
+// headers set. The header map of the caller is not modified.
+func (r ClientRequest) signSnap() (ClientRequest, error) {
+	model := *r.Snap
+
+	header := make(map[string]string, len(r.Header)+7)
+	for key, value := range r.Header {
+		header[key] = value
+	}
+
+	if header["Content-Type"] == "" {
+		header["Content-Type"] = "application/json"
+	}
+
+	timestamp := time.Now().Format(SnapTimestampLayout)
+	header["X-TIMESTAMP"] = timestamp
+
+	privateKey := model.PrivateKey
+	if privateKey == nil && model.PrivateKeyFile != "" {
+		key, err := LoadRsaPrivateKey(model.PrivateKeyFile)
+		if err != nil {
+			return r, *err
+		}
+		privateKey = key
+	}
+
+	body := ""
+	if r.RequestBody != nil {
+		body = *r.RequestBody
+	}
+
+	var signature string
+	var err error
+
+	switch model.Type {
+	case SnapSignatureAccessToken:
+		header["X-CLIENT-KEY"] = model.ClientKey
+		signature, err = SnapAccessTokenSignature(privateKey, model.ClientKey, timestamp)
+	case SnapSignatureSymmetric, SnapSignatureAsymmetric:
+		var errUrl error
+		r, errUrl = r.withQueryInUrl()
+		if errUrl != nil {
+			return r, errUrl
+		}
+
+		endpointUrl, errUrl := snapEndpointUrl(r)
+		if errUrl != nil {
+			return r, errUrl
+		}
+
+		accessToken := ""
+		if model.Type == SnapSignatureSymmetric {
+			accessToken = model.AccessToken
+		}
+
+		stringToSign, errSign := SnapStringToSign(r.HttpMethod, endpointUrl, accessToken, body, timestamp)
+		if errSign != nil {
+			return r, errSign
+		}
+
+		if model.Type == SnapSignatureSymmetric {
+			signature = SnapSymmetricSignature(model.ClientSecret, stringToSign)
+		} else {
+			signature, err = SnapAsymmetricSignature(privateKey, stringToSign)
+		}
+
+		if model.AccessToken != "" {
+			header["Authorization"] = "Bearer " + model.AccessToken
+		}
+
+		header["X-PARTNER-ID"] = model.PartnerId
+		header["X-EXTERNAL-ID"] = model.ExternalId
+		header["CHANNEL-ID"] = model.ChannelId
+	default:
+		return r, errors.New("invalid snap signature type " + model.Type)
+	}
+
+	if err != nil {
+		return r, err
+	}
+
+	header["X-SIGNATURE"] = signature
+	r.Header = header
+
+	return r, nil
+}
+
+// withSnapExternalId returns a copy of the request whose SNAP model has an
+// X-EXTERNAL-ID, so every attempt of a Hit sends the same one and the
+// partner can tell a retry from a new transaction.
+func (r ClientRequest) withSnapExternalId() ClientRequest {
+	if r.Snap == nil || r.Snap.Type == SnapSignatureAccessToken || r.Snap.ExternalId != "" {
+		return r
+	}
+
+	snap := *r.Snap
+	snap.ExternalId = strconv.FormatInt(NewResponseId(), 10)
+	r.Snap = &snap
+
+	return r
+}
+
+// withQueryInUrl moves QueryParam into the url, in key order after the
+// query already in it, so the query that is signed is the one sent.
+func (r ClientRequest) withQueryInUrl() (ClientRequest, error) {
+	if r.QueryParam == nil || len(*r.QueryParam) == 0 {
+		return r, nil
+	}
+
+	parsed, err := url.Parse(r.URL)
+	if err != nil {
+		return r, err
+	}
+
+	query := url.Values{}
+	for key, value := range *r.QueryParam {
+		query.Set(key, value)
+	}
+
+	if parsed.RawQuery != "" {
+		parsed.RawQuery += "&"
+	}
+	parsed.RawQuery += query.Encode()
+
+	r.URL = parsed.String()
+	r.QueryParam = nil
+
+	return r, nil
+}
+
+// snapEndpointUrl is the relative url, with its query as sent, the
+// signature covers.
+func snapEndpointUrl(r ClientRequest) (string, error) {
+	parsed, err := url.Parse(r.URL)
+	if err != nil {
+		return "", err
+	}
+
+	endpointUrl := parsed.EscapedPath()
+	if parsed.RawQuery != "" {
+		endpointUrl += "?" + parsed.RawQuery
+	}
+
+	return endpointUrl, nil
+}
+
+func snapBodyHash(body string) (string, error) {
+	minified := []byte(body)
+	if strings.TrimSpace(body) != "" {
+		var buffer bytes.Buffer
+		if err := json.Compact(&buffer, []byte(body)); err != nil {
+			return "", err
+		}
+		minified = buffer.Bytes()
+	}
+
+	hash := sha256.Sum256(minified)
+	return strings.ToLower(hex.EncodeToString(hash[:])), nil
+}
+
+func signRsaSha256(privateKey *rsa.PrivateKey, stringToSign string) (string, error) {
+	if privateKey == nil {
+		return "", errors.New("snap private key is not configured")
+	}
+
+	hash := sha256.Sum256([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// parsePemPublicKey reads the public key of a CERTIFICATE, an RSA PUBLIC
+// KEY or a PKIX PUBLIC KEY block.
+func parsePemPublicKey(block *pem.Block) (any, error) {
+	switch block.Type {
+	case "CERTIFICATE":
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return certificate.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
+func readPem(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no pem data found in " + path)
+	}
+
+	return block, nil
+}
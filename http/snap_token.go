@@ -0,0 +1,315 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h4lim/og-kds/infra"
+)
+
+const (
+	snapAccessTokenKey         = "snap-access-token"
+	snapAccessTokenServiceCode = "73"
+)
+
+type SnapTokenModel struct {
+	Store SnapPartnerStore
+	// Storage defaults to StorageCache, which keeps the tokens in the
+	// process that issued them. Use StorageRedis when more than one
+	// instance serves the API.
+	Storage       string
+	ExpiresIn     time.Duration
+	TimestampSkew time.Duration
+}
+
+// SnapAccessToken is what an issued B2B access token stands for.
+type SnapAccessToken struct {
+	ClientKey string    `json:"client_key"`
+	PartnerId string    `json:"partner_id"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+type SnapAccessTokenRequest struct {
+	GrantType      string         `json:"grantType"`
+	AdditionalInfo map[string]any `json:"additionalInfo,omitempty"`
+}
+
+type SnapAccessTokenResponse struct {
+	RequestBuildGinSnap
+	AccessToken    string         `json:"accessToken"`
+	TokenType      string         `json:"tokenType"`
+	ExpiresIn      string         `json:"expiresIn"`
+	AdditionalInfo map[string]any `json:"additionalInfo,omitempty"`
+}
+
+func (m SnapTokenModel) withDefaults() SnapTokenModel {
+	if m.Storage == "" {
+		m.Storage = StorageCache
+	}
+
+	if m.ExpiresIn <= 0 {
+		m.ExpiresIn = 15 * time.Minute
+	}
+
+	return m
+}
+
+// SnapAccessToken serves /v1.0/access-token/b2b: it verifies the
+// asymmetric signature of the partner, checks the grant type and issues an
+// opaque bearer token stored for ExpiresIn. It has to run after
+// DeliveryHandler.
+func (m mwContext) SnapAccessToken(model SnapTokenModel) gin.HandlerFunc {
+
+	model = model.withDefaults()
+	verifyModel := SnapVerifyModel{
+		Type:          SnapSignatureAccessToken,
+		ServiceCode:   snapAccessTokenServiceCode,
+		Store:         model.Store,
+		TimestampSkew: model.TimestampSkew,
+	}.withDefaults()
+
+	return func(c *gin.Context) {
+		responseId, language := GetResponseIdAndLanguage(c)
+		response := InitResponse(responseId, language)
+
+		partner, verifyError := verifySnapRequest(c, verifyModel)
+		if verifyError == nil {
+			verifyError = checkSnapGrantType(c)
+		}
+
+		if verifyError != nil {
+			abortSnap(c, response, snapAccessTokenServiceCode, verifyError)
+			return
+		}
+
+		now := time.Now()
+		token := SnapAccessToken{
+			ClientKey: partner.ClientKey,
+			PartnerId: partner.PartnerId,
+			IssuedAt:  now,
+			ExpiredAt: now.Add(model.ExpiresIn),
+		}
+
+		if token.ClientKey == "" {
+			token.ClientKey = c.GetHeader("X-CLIENT-KEY")
+		}
+
+		accessToken, err := issueSnapAccessToken(c.Request.Context(), model, token)
+		if err != nil {
+			response.SetErrorR(err, Tracer(), OptSetR{
+				SnapCase:    &SnapInternalServerError,
+				ServiceCode: snapAccessTokenServiceCode,
+			})
+			abortWithResponse(c, response, true)
+			return
+		}
+
+		response.SetSuccessR(Tracer(), OptSetR{
+			SnapCase:    &SnapSuccessful,
+			ServiceCode: snapAccessTokenServiceCode,
+		})
+
+		httpCode, body := response.BuildGinResponseSnap()
+		c.JSON(httpCode, SnapAccessTokenResponse{
+			RequestBuildGinSnap: body.(RequestBuildGinSnap),
+			AccessToken:         accessToken,
+			TokenType:           "Bearer",
+			ExpiresIn:           strconv.Itoa(int(model.ExpiresIn.Seconds())),
+		})
+	}
+}
+
+// SnapBearer validates the Authorization: Bearer token of a SNAP
+// transaction endpoint against the tokens issued by SnapAccessToken.
+// The token has to belong to the calling partner, the X-PARTNER-ID header
+// or the partner verified by SnapVerify. Failures are answered with the
+// given service code. The token is available through GetSnapAccessToken.
+func (m mwContext) SnapBearer(model SnapTokenModel, serviceCode string) gin.HandlerFunc {
+
+	model = model.withDefaults()
+
+	return func(c *gin.Context) {
+		responseId, language := GetResponseIdAndLanguage(c)
+
+		authorization := c.GetHeader("Authorization")
+		if authorization == "" {
+			abortSnap(c, InitResponse(responseId, language), serviceCode, missingSnapField("Authorization"))
+			return
+		}
+
+		accessToken, found := strings.CutPrefix(authorization, "Bearer ")
+		if !found || accessToken == "" {
+			abortSnap(c, InitResponse(responseId, language), serviceCode, invalidSnapToken())
+			return
+		}
+
+		token, err := loadSnapAccessToken(c.Request.Context(), model.Storage, accessToken)
+		if err != nil {
+			response := InitResponse(responseId, language)
+			response.SetErrorR(err, Tracer(), OptSetR{
+				SnapCase:    &SnapInternalServerError,
+				ServiceCode: serviceCode,
+			})
+			abortWithResponse(c, response, true)
+			return
+		}
+
+		if token == nil || time.Now().After(token.ExpiredAt) || !snapTokenOfCaller(c, *token) {
+			abortSnap(c, InitResponse(responseId, language), serviceCode, invalidSnapToken())
+			return
+		}
+
+		c.Set(snapAccessTokenKey, token)
+		c.Next()
+	}
+}
+
+func snapTokenOfCaller(c *gin.Context, token SnapAccessToken) bool {
+	if partner, ok := GetSnapPartner(c); ok && partner != nil {
+		if partner.ClientKey != "" && partner.ClientKey != token.ClientKey {
+			return false
+		}
+
+		return partner.PartnerId == token.PartnerId
+	}
+
+	return c.GetHeader("X-PARTNER-ID") == token.PartnerId
+}
+
+func GetSnapAccessToken(c *gin.Context) (*SnapAccessToken, bool) {
+	value, exist := c.Get(snapAccessTokenKey)
+	if !exist {
+		return nil, false
+	}
+
+	token, ok := value.(*SnapAccessToken)
+	return token, ok
+}
+
+// RevokeSnapAccessToken removes an issued token before it expires.
+func RevokeSnapAccessToken(ctx context.Context, storage string, accessToken string) *error {
+	key := snapAccessTokenKey + ":" + accessToken
+
+	switch storage {
+	case StorageRedis:
+		if infra.RedisDB == nil {
+			newError := errors.New("redis is not initialized")
+			return &newError
+		}
+
+		_, err := infra.RedisDB.Delete(ctx, key)
+		return err
+	default:
+		if infra.Cache == nil {
+			newError := errors.New("cache is not initialized")
+			return &newError
+		}
+
+		infra.Cache.Delete(key)
+		return nil
+	}
+}
+
+func checkSnapGrantType(c *gin.Context) *snapVerifyError {
+	var request SnapAccessTokenRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		return &snapVerifyError{SnapInvalidFormat, "grantType"}
+	}
+
+	if request.GrantType == "" {
+		return missingSnapField("grantType")
+	}
+
+	if request.GrantType != "client_credentials" {
+		return &snapVerifyError{SnapInvalidFormat, "grantType"}
+	}
+
+	return nil
+}
+
+func issueSnapAccessToken(ctx context.Context, model SnapTokenModel, token SnapAccessToken) (string, *error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", &err
+	}
+
+	accessToken := base64.RawURLEncoding.EncodeToString(raw)
+	reserved, err := reserveKey(ctx, model.Storage, snapAccessTokenKey+":"+accessToken, token, model.ExpiresIn)
+	if err != nil {
+		return "", err
+	}
+
+	if !reserved {
+		newError := errors.New("snap access token collision")
+		return "", &newError
+	}
+
+	return accessToken, nil
+}
+
+func loadSnapAccessToken(ctx context.Context, storage string, accessToken string) (*SnapAccessToken, *error) {
+	key := snapAccessTokenKey + ":" + accessToken
+
+	switch storage {
+	case StorageRedis:
+		if infra.RedisDB == nil {
+			newError := errors.New("redis is not initialized")
+			return nil, &newError
+		}
+
+		value, err := infra.RedisDB.Get(ctx, key)
+		if err != nil {
+			if infra.IsRedisNotFound(*err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		var token SnapAccessToken
+		if err := json.Unmarshal([]byte(value), &token); err != nil {
+			return nil, &err
+		}
+
+		return &token, nil
+	default:
+		if infra.Cache == nil {
+			newError := errors.New("cache is not initialized")
+			return nil, &newError
+		}
+
+		value, found := infra.Cache.Get(key)
+		if !found {
+			return nil, nil
+		}
+
+		token := value.(SnapAccessToken)
+		return &token, nil
+	}
+}
+
+func invalidSnapToken() *snapVerifyError {
+	return &snapVerifyError{SnapInvalidToken, ""}
+}
+
+func abortSnap(c *gin.Context, response Response, serviceCode string, verifyError *snapVerifyError) {
+	message := ""
+	if verifyError.reason != "" {
+		message = verifyError.snapCase.GetMessage(serviceCode, response.Language) + " [" + verifyError.reason + "]"
+	}
+
+	var err error = verifyError
+	response.SetErrorR(&err, Tracer(), OptSetR{
+		SnapCase:    &verifyError.snapCase,
+		ServiceCode: serviceCode,
+		Message:     message,
+	})
+	abortWithResponse(c, response, true)
+}
@@ -0,0 +1,249 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const snapPartnerKey = "snap-partner"
+
+type SnapPartner struct {
+	PartnerId    string
+	ClientKey    string
+	ClientSecret string
+	PublicKey    *rsa.PublicKey
+}
+
+// SnapPartnerStore looks up a partner by its X-CLIENT-KEY for the B2B
+// access token and by its X-PARTNER-ID for transactions. A nil partner
+// means the partner is unknown.
+type SnapPartnerStore interface {
+	GetSnapPartner(ctx context.Context, id string) (*SnapPartner, error)
+}
+
+// StaticSnapPartnerStore keeps partners in memory, keyed by client key or
+// partner id.
+type StaticSnapPartnerStore map[string]SnapPartner
+
+func (s StaticSnapPartnerStore) GetSnapPartner(ctx context.Context, id string) (*SnapPartner, error) {
+	partner, ok := s[id]
+	if !ok {
+		return nil, nil
+	}
+
+	return &partner, nil
+}
+
+type SnapVerifyModel struct {
+	Type                   string
+	ServiceCode            string
+	Store                  SnapPartnerStore
+	TimestampSkew          time.Duration
+	ExternalIdStorage      string
+	DisableExternalIdCheck bool
+}
+
+func (m SnapVerifyModel) withDefaults() SnapVerifyModel {
+	if m.TimestampSkew <= 0 {
+		m.TimestampSkew = 5 * time.Minute
+	}
+
+	if m.ExternalIdStorage == "" {
+		m.ExternalIdStorage = StorageCache
+	}
+
+	return m
+}
+
+type snapVerifyError struct {
+	snapCase SnapCase
+	reason   string
+}
+
+func (e snapVerifyError) Error() string {
+	if e.reason == "" {
+		return e.snapCase.Message
+	}
+
+	return e.snapCase.Message + " [" + e.reason + "]"
+}
+
+// SnapVerify checks the SNAP headers and signature of inbound requests of
+// the given Type and answers failures with BuildGinResponseSnap. It has to
+// run after DeliveryHandler. The verified partner is available through
+// GetSnapPartner.
+func (m mwContext) SnapVerify(model SnapVerifyModel) gin.HandlerFunc {
+
+	model = model.withDefaults()
+
+	return func(c *gin.Context) {
+		partner, err := verifySnapRequest(c, model)
+		if err != nil {
+			responseId, language := GetResponseIdAndLanguage(c)
+			abortSnap(c, InitResponse(responseId, language), model.ServiceCode, err)
+			return
+		}
+
+		c.Set(snapPartnerKey, partner)
+		c.Next()
+	}
+}
+
+func GetSnapPartner(c *gin.Context) (*SnapPartner, bool) {
+	value, exist := c.Get(snapPartnerKey)
+	if !exist {
+		return nil, false
+	}
+
+	partner, ok := value.(*SnapPartner)
+	return partner, ok
+}
+
+func verifySnapRequest(c *gin.Context, model SnapVerifyModel) (*SnapPartner, *snapVerifyError) {
+
+	timestamp := c.GetHeader("X-TIMESTAMP")
+	signature := c.GetHeader("X-SIGNATURE")
+	if timestamp == "" {
+		return nil, missingSnapField("X-TIMESTAMP")
+	}
+
+	if signature == "" {
+		return nil, missingSnapField("X-SIGNATURE")
+	}
+
+	requestTime, err := time.Parse(SnapTimestampLayout, timestamp)
+	if err != nil {
+		requestTime, err = time.Parse(time.RFC3339, timestamp)
+	}
+
+	if err != nil {
+		return nil, &snapVerifyError{SnapInvalidFormat, "X-TIMESTAMP"}
+	}
+
+	if skew := time.Since(requestTime); skew > model.TimestampSkew || skew < -model.TimestampSkew {
+		return nil, &snapVerifyError{SnapUnauthorized, "X-TIMESTAMP expired"}
+	}
+
+	partnerHeader := "X-PARTNER-ID"
+	if model.Type == SnapSignatureAccessToken {
+		partnerHeader = "X-CLIENT-KEY"
+	}
+
+	partnerId := c.GetHeader(partnerHeader)
+	if partnerId == "" {
+		return nil, missingSnapField(partnerHeader)
+	}
+
+	if model.Store == nil {
+		return nil, &snapVerifyError{SnapInternalServerError, "snap partner store"}
+	}
+
+	partner, err := model.Store.GetSnapPartner(c.Request.Context(), partnerId)
+	if err != nil {
+		return nil, &snapVerifyError{SnapInternalServerError, ""}
+	}
+
+	if partner == nil {
+		return nil, &snapVerifyError{SnapUnauthorized, "Unknown client"}
+	}
+
+	rawSignature, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, &snapVerifyError{SnapUnauthorized, "Signature"}
+	}
+
+	if model.Type == SnapSignatureAccessToken {
+		if !verifyRsaSha256(partner.PublicKey, partnerId+"|"+timestamp, rawSignature) {
+			return nil, &snapVerifyError{SnapUnauthorized, "Signature"}
+		}
+
+		return partner, nil
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		return nil, &snapVerifyError{SnapBadRequest, ""}
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	accessToken := ""
+	if model.Type == SnapSignatureSymmetric {
+		accessToken = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	}
+
+	stringToSign, err := SnapStringToSign(c.Request.Method, c.Request.URL.RequestURI(), accessToken, string(body), timestamp)
+	if err != nil {
+		return nil, &snapVerifyError{SnapBadRequest, ""}
+	}
+
+	switch model.Type {
+	case SnapSignatureSymmetric:
+		expected := SnapSymmetricSignature(partner.ClientSecret, stringToSign)
+		if !hmac.Equal([]byte(expected), []byte(signature)) {
+			return nil, &snapVerifyError{SnapUnauthorized, "Signature"}
+		}
+	case SnapSignatureAsymmetric:
+		if !verifyRsaSha256(partner.PublicKey, stringToSign, rawSignature) {
+			return nil, &snapVerifyError{SnapUnauthorized, "Signature"}
+		}
+	default:
+		return nil, &snapVerifyError{SnapInternalServerError, "snap signature type"}
+	}
+
+	if model.DisableExternalIdCheck {
+		return partner, nil
+	}
+
+	externalId := c.GetHeader("X-EXTERNAL-ID")
+	if externalId == "" {
+		return nil, missingSnapField("X-EXTERNAL-ID")
+	}
+
+	if err := reserveSnapExternalId(c.Request.Context(), model, partnerId, externalId); err != nil {
+		return nil, err
+	}
+
+	return partner, nil
+}
+
+// reserveSnapExternalId enforces that a partner uses an X-EXTERNAL-ID only
+// once per day.
+func reserveSnapExternalId(ctx context.Context, model SnapVerifyModel, partnerId string, externalId string) *snapVerifyError {
+	now := time.Now()
+	endOfDay := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	key := "snap-external-id:" + partnerId + ":" + now.Format("20060102") + ":" + externalId
+
+	reserved, err := reserveKey(ctx, model.ExternalIdStorage, key, externalId, endOfDay.Sub(now))
+	if err != nil {
+		return &snapVerifyError{SnapInternalServerError, ""}
+	}
+
+	if !reserved {
+		return &snapVerifyError{SnapConflict, "Cannot use the same X-EXTERNAL-ID in the same day"}
+	}
+
+	return nil
+}
+
+func missingSnapField(field string) *snapVerifyError {
+	return &snapVerifyError{SnapMandatoryField, field}
+}
+
+func verifyRsaSha256(publicKey *rsa.PublicKey, stringToSign string, signature []byte) bool {
+	if publicKey == nil {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(stringToSign))
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature) == nil
+}
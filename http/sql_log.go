@@ -0,0 +1,390 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/h4lim/og-kds/infra"
+	"go.uber.org/zap"
+)
+
+const (
+	SqlLogOverflowDrop  = "drop"
+	SqlLogOverflowBlock = "block"
+	SqlLogOverflowSpill = "spill"
+)
+
+var (
+	sqlLogs   *sqlLogWriter
+	sqlLogsMu sync.Mutex
+)
+
+type SqlLogWriterModel struct {
+	QueueSize      int
+	BatchSize      int
+	FlushInterval  time.Duration
+	OverflowPolicy string
+	SpillFile      string
+}
+
+type SqlLogStats struct {
+	Queued  uint64
+	Written uint64
+	Dropped uint64
+	Spilled uint64
+	Failed  uint64
+}
+
+type sqlLogWriter struct {
+	config      SqlLogWriterModel
+	configSinks []LogSink
+
+	model SqlLogWriterModel
+	sinks []LogSink
+	queue chan sqlLog
+	flush chan chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+
+	spillMu   sync.Mutex
+	closeOnce sync.Once
+
+	queued  atomic.Uint64
+	written atomic.Uint64
+	dropped atomic.Uint64
+	spilled atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// initSqlLogs starts the sqlLog writer and registers its shutdown as an
+// infra shutdown hook. Calling it again with the same config is a no-op;
+// another config is an error while the writer is running.
+func initSqlLogs(model SqlLogWriterModel, sinks []LogSink) *error {
+	sqlLogsMu.Lock()
+	defer sqlLogsMu.Unlock()
+
+	if sqlLogs != nil && !sqlLogs.stopped() {
+		if sqlLogs.sameConfig(model, sinks) {
+			return nil
+		}
+
+		newError := errors.New("sql log writer is already running with another config")
+		return &newError
+	}
+
+	writer := startSqlLogWriter(model, sinks)
+	infra.RegisterShutdownHook(writer.shutdown)
+
+	return nil
+}
+
+func startSqlLogWriter(model SqlLogWriterModel, sinks []LogSink) *sqlLogWriter {
+
+	config, configSinks := model, sinks
+
+	if model.QueueSize <= 0 {
+		model.QueueSize = 10000
+	}
+
+	if model.BatchSize <= 0 {
+		model.BatchSize = 100
+	}
+
+	if model.FlushInterval <= 0 {
+		model.FlushInterval = time.Second
+	}
+
+	if model.OverflowPolicy == "" {
+		model.OverflowPolicy = SqlLogOverflowDrop
+	}
+
+	if model.SpillFile == "" {
+		model.SpillFile = "sql_log_spill.jsonl"
+	}
+
+	if len(sinks) == 0 {
+		sinks = []LogSink{NewGormLogSink(model.BatchSize)}
+	}
+
+	writer := &sqlLogWriter{
+		config:      config,
+		configSinks: configSinks,
+		model:       model,
+		sinks:       sinks,
+		queue:       make(chan sqlLog, model.QueueSize),
+		flush:       make(chan chan struct{}),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	go writer.run()
+	sqlLogs = writer
+
+	return writer
+}
+
+func saveSqlLog(data sqlLog) {
+	if sqlLogs == nil {
+		return
+	}
+
+	if data.CreatedAt.IsZero() {
+		data.CreatedAt = time.Now()
+	}
+
+	sqlLogs.enqueue(data)
+}
+
+// GetSqlLogStats returns the counters of the sqlLog writer since InitHttp.
+func GetSqlLogStats() SqlLogStats {
+	if sqlLogs == nil {
+		return SqlLogStats{}
+	}
+
+	return SqlLogStats{
+		Queued:  sqlLogs.queued.Load(),
+		Written: sqlLogs.written.Load(),
+		Dropped: sqlLogs.dropped.Load(),
+		Spilled: sqlLogs.spilled.Load(),
+		Failed:  sqlLogs.failed.Load(),
+	}
+}
+
+// FlushSqlLogs writes everything queued so far or gives up when ctx is
+// done.
+func FlushSqlLogs(ctx context.Context) error {
+	if sqlLogs == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	select {
+	case sqlLogs.flush <- done:
+	case <-sqlLogs.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown stops the worker once it has written what is queued, then
+// closes the sinks. When ctx is done first the sinks are left open since
+// the worker may still be writing to them.
+func (w *sqlLogWriter) shutdown(ctx context.Context) error {
+	select {
+	case w.stop <- struct{}{}:
+	case <-w.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var errs []error
+	w.closeOnce.Do(func() {
+		for _, sink := range w.sinks {
+			if err := sink.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+
+	return errors.Join(errs...)
+}
+
+func (w *sqlLogWriter) stopped() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *sqlLogWriter) sameConfig(model SqlLogWriterModel, sinks []LogSink) bool {
+	if model != w.config || len(sinks) != len(w.configSinks) {
+		return false
+	}
+
+	for i, sink := range sinks {
+		other := w.configSinks[i]
+		if reflect.TypeOf(sink) != reflect.TypeOf(other) || !reflect.TypeOf(sink).Comparable() || sink != other {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (w *sqlLogWriter) enqueue(data sqlLog) {
+
+	if w.stopped() {
+		w.dropped.Add(1)
+		return
+	}
+
+	switch w.model.OverflowPolicy {
+	case SqlLogOverflowBlock:
+		select {
+		case w.queue <- data:
+			w.queued.Add(1)
+		case <-w.done:
+			w.dropped.Add(1)
+		}
+	case SqlLogOverflowSpill:
+		select {
+		case w.queue <- data:
+			w.queued.Add(1)
+		default:
+			w.spill([]sqlLog{data})
+		}
+	default:
+		select {
+		case w.queue <- data:
+			w.queued.Add(1)
+		default:
+			w.dropped.Add(1)
+		}
+	}
+}
+
+func (w *sqlLogWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.model.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]sqlLog, 0, w.model.BatchSize)
+	for {
+		select {
+		case data := <-w.queue:
+			batch = append(batch, data)
+			if len(batch) >= w.model.BatchSize {
+				batch = w.write(batch)
+			}
+		case <-ticker.C:
+			batch = w.write(batch)
+		case done := <-w.flush:
+			batch = w.drain(batch)
+			batch = w.write(batch)
+			close(done)
+		case <-w.stop:
+			batch = w.drain(batch)
+			w.write(batch)
+			return
+		}
+	}
+}
+
+func (w *sqlLogWriter) drain(batch []sqlLog) []sqlLog {
+	for {
+		select {
+		case data := <-w.queue:
+			batch = append(batch, data)
+			if len(batch) >= w.model.BatchSize {
+				batch = w.write(batch)
+			}
+		default:
+			return batch
+		}
+	}
+}
+
+// write hands batch to every sink and returns it emptied for reuse. A sink
+// that fails is retried once with the entries it did not write; what is
+// still left is spilled to the spill file of that sink under the spill
+// policy and counted as failed otherwise, so no entry reaches a sink twice.
+func (w *sqlLogWriter) write(batch []sqlLog) []sqlLog {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	failed := false
+	for i, sink := range w.sinks {
+		pending := batch
+		err := sink.Write(pending)
+		if err != nil {
+			pending = pending[logSinkWritten(err):]
+			err = sink.Write(pending)
+		}
+
+		if err == nil {
+			continue
+		}
+		pending = pending[logSinkWritten(err):]
+
+		failed = true
+		if infra.ZapLog != nil {
+			infra.ZapLog.Warn("write sql logs failed", zap.Int("size", len(pending)),
+				zap.Int("sink", i), zap.String("error", err.Error()))
+		}
+
+		if w.model.OverflowPolicy == SqlLogOverflowSpill {
+			w.spillTo(w.sinkSpillFile(i), pending)
+		} else {
+			w.failed.Add(uint64(len(pending)))
+		}
+	}
+
+	if !failed {
+		w.written.Add(uint64(len(batch)))
+	}
+
+	return batch[:0]
+}
+
+// sinkSpillFile is SpillFile for a single sink. With several sinks the
+// sink index goes before the extension, e.g. sql_log_spill.sink-1.jsonl.
+func (w *sqlLogWriter) sinkSpillFile(index int) string {
+	if len(w.sinks) == 1 {
+		return w.model.SpillFile
+	}
+
+	extension := filepath.Ext(w.model.SpillFile)
+	return strings.TrimSuffix(w.model.SpillFile, extension) + ".sink-" + strconv.Itoa(index) + extension
+}
+
+func (w *sqlLogWriter) spill(batch []sqlLog) {
+	w.spillTo(w.model.SpillFile, batch)
+}
+
+func (w *sqlLogWriter) spillTo(path string, batch []sqlLog) {
+	w.spillMu.Lock()
+	defer w.spillMu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		w.dropped.Add(uint64(len(batch)))
+		return
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, data := range batch {
+		if err := encoder.Encode(data); err != nil {
+			w.dropped.Add(1)
+			continue
+		}
+		w.spilled.Add(1)
+	}
+}
@@ -0,0 +1,262 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TimeoutModel struct {
+	Timeout     time.Duration
+	Snap        bool
+	HttpCode    int
+	Code        string
+	ServiceCode string
+}
+
+type timeoutData struct {
+	TimedOut bool   `json:"timed_out"`
+	Timeout  string `json:"timeout"`
+}
+
+// timeoutWriter buffers what the handler writes so that nothing reaches the
+// client before the handler is done in time, and drops every write once the
+// request has timed out.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.timedOut && w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	return w.body.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.status == 0 && w.body.Len() == 0 {
+		return -1
+	}
+
+	return w.body.Len()
+}
+
+func (w *timeoutWriter) Written() bool {
+	return w.Size() != -1
+}
+
+func (w *timeoutWriter) Flush() {
+}
+
+// flush hands the buffered response of a handler done in time to the real
+// writer.
+func (w *timeoutWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	header := w.ResponseWriter.Header()
+	for key, values := range w.header {
+		header[key] = values
+	}
+
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+
+	if w.body.Len() > 0 {
+		_, _ = w.ResponseWriter.Write(w.body.Bytes())
+	}
+}
+
+func (w *timeoutWriter) timeout() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.timedOut = true
+}
+
+// Timeout runs the rest of the chain with a context deadline of
+// model.Timeout. When the handler is not done in time the client gets the
+// timeout code of model right away and the step is logged as timed out;
+// whatever the abandoned handler writes afterwards is discarded. The
+// handler keeps the request until it returns, so it should watch
+// c.Request.Context(). A request cancelled before the deadline, e.g. by a
+// client that went away, is not answered or logged as timed out. It has
+// to run after DeliveryHandler.
+func (m mwContext) Timeout(model TimeoutModel) gin.HandlerFunc {
+
+	if model.Timeout <= 0 {
+		model.Timeout = 30 * time.Second
+	}
+
+	if model.HttpCode == 0 {
+		model.HttpCode = http.StatusGatewayTimeout
+	}
+
+	return func(c *gin.Context) {
+		responseId, language := GetResponseIdAndLanguage(c)
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), model.Timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		if trace, ok := TraceFromContext(c); ok {
+			trace.SetContext(ctx)
+		}
+
+		original := c.Writer
+		writer := &timeoutWriter{ResponseWriter: original, header: make(http.Header)}
+		c.Writer = writer
+
+		done := make(chan *recoveredPanic, 1)
+		go func() {
+			var panicked *recoveredPanic
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					panicked = &recoveredPanic{value: recovered, stack: debug.Stack(), tracer: panicTracer()}
+				}
+				done <- panicked
+			}()
+
+			c.Next()
+		}()
+
+		var panicked *recoveredPanic
+		timedOut := false
+		select {
+		case panicked = <-done:
+		case <-ctx.Done():
+			// a cancelled request, e.g. a client gone away, is not a timeout:
+			// the handler finishes as usual with nobody to answer
+			timedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+			if !timedOut {
+				panicked = <-done
+			}
+		}
+
+		if !timedOut {
+			c.Writer = original
+			if panicked != nil && panicked.value == http.ErrAbortHandler {
+				panic(http.ErrAbortHandler)
+			}
+
+			if panicked != nil {
+				panic(*panicked)
+			}
+
+			writer.flush()
+			return
+		}
+
+		writer.timeout()
+
+		// building the response releases the trace the late handler still
+		// uses, keep it until the handler returns
+		trace, held := LoadTrace(responseId)
+		writeTimeout(original, responseId, language, model)
+		if held {
+			traces.Store(responseId, trace)
+		}
+
+		// the handler still holds c, wait for it before gin recycles the context
+		panicked = <-done
+		c.Writer = original
+		ReleaseTrace(responseId)
+		c.Abort()
+
+		if panicked != nil {
+			warnLatePanic(responseId, panicked)
+		}
+	}
+}
+
+func writeTimeout(writer gin.ResponseWriter, responseId int64, language string, model TimeoutModel) {
+	response := InitResponse(responseId, language)
+	errTimeout := fmt.Errorf("handler timed out after %s", model.Timeout)
+
+	optData := OptSetR{
+		HttpCode:    model.HttpCode,
+		Code:        model.Code,
+		ServiceCode: model.ServiceCode,
+		Data:        timeoutData{TimedOut: true, Timeout: model.Timeout.String()},
+	}
+
+	if model.Snap && model.Code == "" {
+		optData.SnapCase = &SnapTimeout
+	}
+
+	response.SetAdditionalTracer("timed-out")
+	response.SetErrorR(&errTimeout, Tracer(), optData)
+
+	var httpCode int
+	var body any
+	if model.Snap {
+		httpCode, body = response.BuildGinResponseSnap()
+	} else {
+		httpCode, body = response.BuildGinResponse()
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		data = []byte("{}")
+	}
+
+	header := writer.Header()
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	writer.WriteHeader(httpCode)
+	_, _ = writer.Write(data)
+	writer.Flush()
+}
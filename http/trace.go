@@ -0,0 +1,158 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const traceKey = "trace"
+
+type traceContextKey struct{}
+
+var traces sync.Map
+
+type Trace struct {
+	ResponseID int64
+
+	mu            sync.Mutex
+	ctx           context.Context
+	requestId     string
+	step          int
+	startedAt     int64
+	lastTimestamp int64
+}
+
+func NewTrace(responseId int64) *Trace {
+	now := time.Now().UnixNano()
+	return &Trace{
+		ResponseID:    responseId,
+		step:          1,
+		startedAt:     now,
+		lastTimestamp: now,
+	}
+}
+
+// StartTrace creates a trace for responseId and registers it so the
+// responseId based helpers can find it until ReleaseTrace is called.
+func StartTrace(responseId int64) *Trace {
+	trace := NewTrace(responseId)
+	traces.Store(responseId, trace)
+	return trace
+}
+
+func LoadTrace(responseId int64) (*Trace, bool) {
+	value, ok := traces.Load(responseId)
+	if !ok {
+		return nil, false
+	}
+
+	return value.(*Trace), true
+}
+
+func ReleaseTrace(responseId int64) {
+	traces.Delete(responseId)
+}
+
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, trace)
+}
+
+func TraceFromContext(ctx context.Context) (*Trace, bool) {
+	if c, ok := ctx.(*gin.Context); ok {
+		value, exist := c.Get(traceKey)
+		if exist {
+			trace, ok := value.(*Trace)
+			return trace, ok
+		}
+
+		if c.Request == nil {
+			return nil, false
+		}
+		ctx = c.Request.Context()
+	}
+
+	trace, ok := ctx.Value(traceContextKey{}).(*Trace)
+	return trace, ok
+}
+
+// Context returns the context of the traced inbound request, or
+// context.Background when none was bound.
+func (t *Trace) Context() context.Context {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ctx == nil {
+		return context.Background()
+	}
+
+	return t.ctx
+}
+
+func (t *Trace) SetContext(ctx context.Context) {
+	t.mu.Lock()
+	t.ctx = ctx
+	t.mu.Unlock()
+}
+
+func (t *Trace) RequestID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.requestId
+}
+
+func (t *Trace) SetRequestID(requestId string) {
+	t.mu.Lock()
+	t.requestId = requestId
+	t.mu.Unlock()
+}
+
+func (t *Trace) Step() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.step
+}
+
+func (t *Trace) NextStep() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.step++
+	return t.step
+}
+
+// Duration returns the milliseconds elapsed since the previous call and
+// moves the step timestamp forward.
+func (t *Trace) Duration() string {
+	now := time.Now().UnixNano()
+
+	t.mu.Lock()
+	elapsed := now - t.lastTimestamp
+	t.lastTimestamp = now
+	t.mu.Unlock()
+
+	return fmt.Sprintf("%v", float64(elapsed)/float64(time.Millisecond))
+}
+
+func (t *Trace) TotalDuration() string {
+	elapsed := time.Now().UnixNano() - t.startedAt
+	return fmt.Sprintf("%v", float64(elapsed)/float64(time.Millisecond))
+}
+
+// getTrace returns the registered trace of responseId. An unknown or
+// released responseId gets a fresh trace that is not registered, so helper
+// calls after ReleaseTrace do not bring the entry back.
+func getTrace(responseId int64) *Trace {
+	if trace, ok := LoadTrace(responseId); ok {
+		return trace
+	}
+
+	now := time.Now().UnixNano()
+	return &Trace{
+		ResponseID:    responseId,
+		startedAt:     now,
+		lastTimestamp: now,
+	}
+}
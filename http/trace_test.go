@@ -0,0 +1,115 @@
+package http
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func countTraces() int {
+	count := 0
+	traces.Range(func(key, value any) bool {
+		count++
+		return true
+	})
+
+	return count
+}
+
+func TestTraceParallelRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(NewMw().DeliveryHandler)
+	router.POST("/trace", func(c *gin.Context) {
+		responseId, language := GetResponseIdAndLanguage(c)
+
+		trace, ok := TraceFromContext(c)
+		if !ok || trace.ResponseID != responseId {
+			t.Errorf("trace of %d not bound to the gin context", responseId)
+		}
+
+		if fromRequest, ok := TraceFromContext(c.Request.Context()); !ok || fromRequest != trace {
+			t.Errorf("trace of %d not bound to the request context", responseId)
+		}
+
+		if step := GetNextStep(responseId); step != "2" {
+			t.Errorf("expected step 2, got %s", step)
+		}
+
+		requestId := c.Query("id")
+		if GetRequestId(responseId) != requestId {
+			t.Errorf("expected request id %s, got %s", requestId, GetRequestId(responseId))
+		}
+
+		response := InitResponse(responseId, language)
+		response.SetSuccessR(Tracer())
+		c.JSON(response.BuildGinResponse())
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5000; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			requestId := fmt.Sprintf("req-%d", i)
+			body := strings.NewReader(`{"request_id":"` + requestId + `"}`)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest("POST", "/trace?id="+requestId, body))
+
+			if recorder.Code != 200 {
+				t.Errorf("expected 200, got %d", recorder.Code)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if count := countTraces(); count != 0 {
+		t.Fatalf("%d traces left after the requests", count)
+	}
+}
+
+func TestTraceNotRecreatedAfterRelease(t *testing.T) {
+	trace := StartTrace(NewResponseId())
+	trace.SetRequestID("req")
+	GetNextStep(trace.ResponseID)
+
+	ReleaseTrace(trace.ResponseID)
+
+	if GetRequestId(trace.ResponseID) != "" {
+		t.Fatal("released trace still answers the request id")
+	}
+
+	GetNextStep(trace.ResponseID)
+	if _, ok := LoadTrace(trace.ResponseID); ok {
+		t.Fatal("helper call registered the released trace again")
+	}
+}
+
+func TestTraceReleasedWithoutResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(NewMw().DeliveryHandler)
+	router.GET("/json", func(c *gin.Context) {
+		c.JSON(200, gin.H{"ok": true})
+	})
+	router.GET("/abort", func(c *gin.Context) {
+		c.AbortWithStatus(403)
+	})
+
+	for i := 0; i < 100; i++ {
+		for _, path := range []string{"/json", "/abort"} {
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}
+	}
+
+	if count := countTraces(); count != 0 {
+		t.Fatalf("%d traces left after the requests", count)
+	}
+}
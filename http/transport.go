@@ -0,0 +1,64 @@
+package http
+
+import (
+	"context"
+	"errors"
+
+	party "github.com/h4lim/client-party"
+)
+
+// DefaultTransport sends the requests of every client without its own
+// ClientRequest.Transport. Tests can swap it for a mock.
+var DefaultTransport Transport = PartyTransport{}
+
+// Transport performs a single outbound exchange for ClientContext.Hit.
+// Retries, circuit breaking and logging stay in Hit.
+type Transport interface {
+	RoundTrip(ctx context.Context, request ClientRequest) (*party.Response, error)
+}
+
+// PartyTransport sends requests through client-party. client-party takes
+// no context, so a cancelled or timed out request is abandoned rather than
+// aborted; see ErrClientAbandoned.
+type PartyTransport struct {
+}
+
+func (p PartyTransport) RoundTrip(ctx context.Context, request ClientRequest) (*party.Response, error) {
+
+	clientParty := party.NewClientParty(request.HttpMethod, request.URL).
+		SetHeader(request.Header["Content-Type"], request.Header)
+
+	if request.RequestBody != nil {
+		clientParty.SetRequestBodyStr(*request.RequestBody)
+	}
+
+	if request.QueryParam != nil {
+		clientParty.SetQueryParam(*request.QueryParam)
+	}
+
+	if request.Username != nil && request.Password != nil {
+		clientParty.SetBaseAuth(*request.Username, *request.Password)
+	}
+
+	return request.call(ctx, clientParty.HitClient)
+}
+
+func (r ClientRequest) roundTrip(ctx context.Context) (*party.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	response, err := transport.RoundTrip(ctx, r)
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ErrClientAbandoned) {
+		return nil, contextError(ctx.Err(), r)
+	}
+
+	return response, err
+}
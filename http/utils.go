@@ -15,10 +15,7 @@ import (
 )
 
 var (
-	UnixTimestamp map[int64]int64
-	Step          map[int64]int
-	RequestId     map[int64]string
-	OptConfig     OptConfigModel
+	OptConfig OptConfigModel
 )
 
 type PackageInformationModel struct {
@@ -34,15 +31,13 @@ type RequestHeaderSnap struct {
 	XTimestamp            string
 	XSignature            string
 	XPartnerId            string
-	XEternalId            string
-	ChannelId             string
+	XExternalId           string
+	// Deprecated: use XExternalId, it holds the same value.
+	XEternalId string
+	ChannelId  string
 }
 
 func InitHttp(config OptConfigModel) {
-	RequestId = make(map[int64]string)
-	UnixTimestamp = make(map[int64]int64)
-	Step = make(map[int64]int)
-
 	setOptionalConfig(config)
 }
 
@@ -54,7 +49,12 @@ func GetHeaderSnapTransaction(c *gin.Context) RequestHeaderSnap {
 	headerSnap.XTimestamp = c.GetHeader("X-Timestamp")
 	headerSnap.XSignature = c.GetHeader("X-Signature")
 	headerSnap.XPartnerId = c.GetHeader("X-Partner-Id")
-	headerSnap.XEternalId = c.GetHeader("X-Eternal-Id")
+	headerSnap.XExternalId = c.GetHeader("X-External-ID")
+	if headerSnap.XExternalId == "" {
+		// callers used to send the misspelled header this function read
+		headerSnap.XExternalId = c.GetHeader("X-Eternal-Id")
+	}
+	headerSnap.XEternalId = headerSnap.XExternalId
 	headerSnap.ChannelId = c.GetHeader("Channel-Id")
 	headerSnap.AcceptLanguage = "EN"
 
@@ -84,32 +84,27 @@ func GenerateSession() string {
 }
 
 func GetDuration(responseId int64) string {
+	return getTrace(responseId).Duration()
+}
 
-	newUnixNano := time.Now().UnixNano()
-	duration := UnixTimestamp[responseId]
-	elapsed := newUnixNano - duration
-	UnixTimestamp[responseId] = newUnixNano
-	ms := float64(elapsed) / float64(time.Millisecond)
-
-	return fmt.Sprintf("%v", ms)
+func GetTotalDuration(responseId int64) string {
+	return getTrace(responseId).TotalDuration()
 }
 
 func GetRequestId(responseId int64) string {
-	return RequestId[responseId]
+	return getTrace(responseId).RequestID()
 }
 
 func GetStepInt(responseId int64) int {
-	return Step[responseId]
+	return getTrace(responseId).Step()
 }
 
 func GetStep(responseId int64) string {
-	return strconv.Itoa(Step[responseId])
+	return strconv.Itoa(getTrace(responseId).Step())
 }
 
 func GetNextStep(responseId int64) string {
-	step := Step[responseId]
-	Step[responseId] = step + 1
-	return strconv.Itoa(Step[responseId])
+	return strconv.Itoa(getTrace(responseId).NextStep())
 }
 
 func Tracer() TracerModel {
@@ -136,12 +131,12 @@ func GetResponseIdAndLanguage(c *gin.Context) (int64, string) {
 	language := getLanguage(c)
 	responseId, exist := c.Get("response-id")
 	if !exist {
-		return time.Now().UnixNano(), language
+		return NewResponseId(), language
 	}
 
 	int64Value, ok := responseId.(int64)
 	if !ok {
-		return time.Now().UnixNano(), language
+		return NewResponseId(), language
 	}
 
 	return int64Value, language
@@ -185,6 +180,15 @@ func GetRequestIdFromRequest(rawBody []byte) string {
 	return requestId
 }
 
+func abortWithResponse(c *gin.Context, r Response, snap bool) {
+	if snap {
+		c.AbortWithStatusJSON(r.BuildGinResponseSnap())
+		return
+	}
+
+	c.AbortWithStatusJSON(r.BuildGinResponse())
+}
+
 func jsonMarshal(v interface{}) string {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -201,3 +205,22 @@ func getFunctionName(fnName string) string {
 
 	return fnName
 }
+
+// callerPartnerId is the partner verified by SnapVerify or SnapBearer, or
+// the subject of the JwtAuth claims, falling back to the header set by the
+// client when none of them ran.
+func callerPartnerId(c *gin.Context, header string) string {
+	if partner, ok := GetSnapPartner(c); ok && partner != nil {
+		return partner.PartnerId
+	}
+
+	if token, ok := GetSnapAccessToken(c); ok && token != nil {
+		return token.PartnerId
+	}
+
+	if claims, ok := GetJwtClaims(c); ok && claims != nil {
+		return claims.Subject
+	}
+
+	return c.GetHeader(header)
+}
@@ -1,29 +1,150 @@
 package infra
 
 import (
-	"golang.org/x/sync/errgroup"
+	"context"
+	"crypto/tls"
+	"errors"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
+var (
+	shutdownHooks   []func(ctx context.Context) error
+	shutdownHooksMu sync.Mutex
+)
+
+// ServerModel configures Run. ShutdownTimeout bounds the drain of in-flight
+// requests, 30s by default, and ShutdownHookTimeout the shutdown hooks run
+// afterwards with their own deadline, 10s by default.
 type ServerModel struct {
-	Port string
+	Port                string
+	CertFile            string
+	KeyFile             string
+	ReadTimeout         time.Duration
+	ReadHeaderTimeout   time.Duration
+	WriteTimeout        time.Duration
+	IdleTimeout         time.Duration
+	ShutdownTimeout     time.Duration
+	ShutdownHookTimeout time.Duration
 }
 
 type IServerConfig interface {
 	Run(server *http.Server) *error
+	RunHandler(handler http.Handler) *error
 }
 
 func NewServerConfig(model ServerModel) IServerConfig {
 	return ServerModel{
-		Port: model.Port,
+		Port:                model.Port,
+		CertFile:            model.CertFile,
+		KeyFile:             model.KeyFile,
+		ReadTimeout:         model.ReadTimeout,
+		ReadHeaderTimeout:   model.ReadHeaderTimeout,
+		WriteTimeout:        model.WriteTimeout,
+		IdleTimeout:         model.IdleTimeout,
+		ShutdownTimeout:     model.ShutdownTimeout,
+		ShutdownHookTimeout: model.ShutdownHookTimeout,
+	}
+}
+
+// RegisterShutdownHook adds a function run by Run after the server stopped
+// accepting connections and drained in-flight requests, e.g. to flush
+// pending log writes.
+func RegisterShutdownHook(hook func(ctx context.Context) error) {
+	shutdownHooksMu.Lock()
+	defer shutdownHooksMu.Unlock()
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+// RunHandler builds the http.Server from the model timeouts and runs it.
+func (s ServerModel) RunHandler(handler http.Handler) *error {
+
+	server := &http.Server{
+		Addr:              ":" + s.Port,
+		Handler:           handler,
+		ReadTimeout:       s.ReadTimeout,
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
+		WriteTimeout:      s.WriteTimeout,
+		IdleTimeout:       s.IdleTimeout,
 	}
+
+	return s.Run(server)
 }
 
+// Run serves until SIGINT or SIGTERM, then shuts the server down gracefully
+// and runs the shutdown hooks, all within ShutdownTimeout. TLS is used when
+// CertFile and KeyFile are set; the pair is reloaded on SIGHUP.
 func (s ServerModel) Run(server *http.Server) *error {
 
+	if server.Addr == "" && s.Port != "" {
+		server.Addr = ":" + s.Port
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	var groupRouter errgroup.Group
 	groupRouter.Go(func() error {
-		return server.ListenAndServe()
+		defer stop()
+
+		var err error
+		if s.CertFile != "" && s.KeyFile != "" {
+			reloader, errLoad := newCertReloader(s.CertFile, s.KeyFile)
+			if errLoad != nil {
+				return errLoad
+			}
+
+			go reloader.watch(ctx)
+
+			if server.TLSConfig == nil {
+				server.TLSConfig = &tls.Config{}
+			}
+			server.TLSConfig.GetCertificate = reloader.getCertificate
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+
+		return err
+	})
+
+	groupRouter.Go(func() error {
+		<-ctx.Done()
+
+		shutdownTimeout := s.ShutdownTimeout
+		if shutdownTimeout <= 0 {
+			shutdownTimeout = 30 * time.Second
+		}
+
+		hookTimeout := s.ShutdownHookTimeout
+		if hookTimeout <= 0 {
+			hookTimeout = 10 * time.Second
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		errShutdown := server.Shutdown(shutdownCtx)
+
+		// the hooks get their own deadline, a slow drain must not leave
+		// them an expired context
+		hookCtx, cancelHooks := context.WithTimeout(context.Background(), hookTimeout)
+		defer cancelHooks()
+
+		errHooks := runShutdownHooks(hookCtx)
+
+		return errors.Join(errShutdown, errHooks)
 	})
 
 	if err := groupRouter.Wait(); err != nil {
@@ -32,3 +153,75 @@ func (s ServerModel) Run(server *http.Server) *error {
 
 	return nil
 }
+
+func runShutdownHooks(ctx context.Context) error {
+	shutdownHooksMu.Lock()
+	hooks := append([]func(ctx context.Context) error{}, shutdownHooks...)
+	shutdownHooksMu.Unlock()
+
+	var errs []error
+	for _, hook := range hooks {
+		if err := hook(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (c *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *certReloader) watch(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			if err := c.load(); err != nil && ZapLog != nil {
+				ZapLog.Warn("reload tls certificate failed", zap.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
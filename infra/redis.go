@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -12,23 +14,55 @@ import (
 
 var RedisDB IRedisConfig
 
-const (
-	JsonRedis  = "1"
-	XmlType    = "2"
-	SingleType = "3"
-)
+var redisPools sync.Map
 
 type RedisModel struct {
 	Domain         string
 	Port           string
 	Password       string
 	SecondDuration int
+	DB             int
+	PoolSize       int
+	MinIdleConns   int
+	DialTimeout    time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+
+	pool *redisPool
+}
+
+type redisPool struct {
+	mu     sync.Mutex
+	client *redis.Client
+}
+
+// RedisNotFoundError is returned when a key does not exist, so callers can
+// tell a cache miss apart from a connection failure.
+type RedisNotFoundError struct {
+	Key string
+}
+
+func (e RedisNotFoundError) Error() string {
+	return "redis key not found: " + e.Key
 }
 
 type IRedisConfig interface {
 	Open() *error
-	Set(key string, redisType string, value any, duration int) *error
-	Get(key string, redisType string) (any, *error)
+	Close() *error
+	Client() (*redis.Client, *error)
+	Set(ctx context.Context, key string, value any, expiration time.Duration) *error
+	SetNX(ctx context.Context, key string, value any, expiration time.Duration) (bool, *error)
+	SetJSON(ctx context.Context, key string, value any, expiration time.Duration) *error
+	SetXML(ctx context.Context, key string, value any, expiration time.Duration) *error
+	Get(ctx context.Context, key string) (string, *error)
+	Delete(ctx context.Context, keys ...string) (int64, *error)
+	Exists(ctx context.Context, keys ...string) (int64, *error)
+	TTL(ctx context.Context, key string) (time.Duration, *error)
+	Expire(ctx context.Context, key string, expiration time.Duration) (bool, *error)
+	Incr(ctx context.Context, key string) (int64, *error)
+	Decr(ctx context.Context, key string) (int64, *error)
+	MGet(ctx context.Context, keys ...string) (map[string]string, *error)
+	MSet(ctx context.Context, values map[string]any) *error
 }
 
 func InitRedis(model RedisModel) {
@@ -37,111 +71,363 @@ func InitRedis(model RedisModel) {
 
 func NewRedisConfig(model RedisModel) IRedisConfig {
 	return RedisModel{
-		Domain:   model.Domain,
-		Port:     model.Port,
-		Password: model.Password,
+		Domain:         model.Domain,
+		Port:           model.Port,
+		Password:       model.Password,
+		SecondDuration: model.SecondDuration,
+		DB:             model.DB,
+		PoolSize:       model.PoolSize,
+		MinIdleConns:   model.MinIdleConns,
+		DialTimeout:    model.DialTimeout,
+		ReadTimeout:    model.ReadTimeout,
+		WriteTimeout:   model.WriteTimeout,
+		pool:           &redisPool{},
 	}
 }
 
+// Open builds the pooled client shared by every call on the model. Calling
+// it again while the client is open is a no-op.
 func (r RedisModel) Open() *error {
 
-	if _, err := open(r); err != nil {
+	if _, err := r.getClient(); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (r RedisModel) Set(key string, redisType string, value any, duration int) *error {
+func (r RedisModel) Close() *error {
+
+	pool := r.redisPoolFor()
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
 
-	client, err := open(r)
+	if pool.client == nil {
+		return nil
+	}
+
+	err := pool.client.Close()
+	pool.client = nil
+	if err != nil {
+		return &err
+	}
+
+	return nil
+}
+
+// Client exposes the pooled client for commands the model does not wrap.
+func (r RedisModel) Client() (*redis.Client, *error) {
+	return r.getClient()
+}
+
+func (r RedisModel) Set(ctx context.Context, key string, value any, expiration time.Duration) *error {
+
+	client, err := r.getClient()
 	if err != nil {
 		return err
 	}
 
-	_duration := time.Duration(duration) * time.Second
-	switch redisType {
-	case JsonRedis:
-		jsonResult, err := json.Marshal(&value)
-		if err != nil {
-			return &err
-		}
+	if err := client.Set(ctx, key, value, expiration).Err(); err != nil {
+		return &err
+	}
 
-		if cmdStatus := client.Set(context.Background(), key, string(jsonResult), _duration); cmdStatus != nil {
-			newError := errors.New(cmdStatus.String())
-			return &newError
-		}
+	return nil
+}
 
-		return nil
-	case XmlType:
-		jsonResult, err := xml.Marshal(&value)
-		if err != nil {
-			return &err
-		}
+func (r RedisModel) SetNX(ctx context.Context, key string, value any, expiration time.Duration) (bool, *error) {
 
-		if cmdStatus := client.Set(context.Background(), key, string(jsonResult), _duration); cmdStatus != nil {
-			newError := errors.New(cmdStatus.String())
-			return &newError
-		}
+	client, err := r.getClient()
+	if err != nil {
+		return false, err
+	}
 
-		return nil
-	case SingleType:
-		if cmdStatus := client.Set(context.Background(), key, value, _duration); cmdStatus != nil {
-			newError := errors.New(cmdStatus.String())
-			return &newError
-		}
+	ok, errSet := client.SetNX(ctx, key, value, expiration).Result()
+	if errSet != nil {
+		return false, &errSet
+	}
 
-		return nil
-	default:
-		newError := errors.New("invalid redis type")
-		return &newError
+	return ok, nil
+}
+
+func (r RedisModel) SetJSON(ctx context.Context, key string, value any, expiration time.Duration) *error {
+
+	jsonResult, err := json.Marshal(value)
+	if err != nil {
+		return &err
+	}
+
+	return r.Set(ctx, key, string(jsonResult), expiration)
+}
+
+func (r RedisModel) SetXML(ctx context.Context, key string, value any, expiration time.Duration) *error {
+
+	xmlResult, err := xml.Marshal(value)
+	if err != nil {
+		return &err
+	}
+
+	return r.Set(ctx, key, string(xmlResult), expiration)
+}
+
+func (r RedisModel) Get(ctx context.Context, key string) (string, *error) {
+
+	client, err := r.getClient()
+	if err != nil {
+		return "", err
+	}
+
+	value, errGet := client.Get(ctx, key).Result()
+	if errGet != nil {
+		errGet = notFoundError(errGet, key)
+		return "", &errGet
+	}
+
+	return value, nil
+}
+
+func (r RedisModel) Delete(ctx context.Context, keys ...string) (int64, *error) {
+
+	client, err := r.getClient()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, errDel := client.Del(ctx, keys...).Result()
+	if errDel != nil {
+		return 0, &errDel
+	}
+
+	return deleted, nil
+}
+
+func (r RedisModel) Exists(ctx context.Context, keys ...string) (int64, *error) {
+
+	client, err := r.getClient()
+	if err != nil {
+		return 0, err
+	}
+
+	exist, errExists := client.Exists(ctx, keys...).Result()
+	if errExists != nil {
+		return 0, &errExists
+	}
+
+	return exist, nil
+}
+
+// TTL returns the remaining time to live of key, -1 when the key has no
+// expiry and a RedisNotFoundError when it does not exist.
+func (r RedisModel) TTL(ctx context.Context, key string) (time.Duration, *error) {
+
+	client, err := r.getClient()
+	if err != nil {
+		return 0, err
 	}
 
+	ttl, errTTL := client.TTL(ctx, key).Result()
+	if errTTL != nil {
+		return 0, &errTTL
+	}
+
+	if ttl == -2 {
+		newError := error(RedisNotFoundError{Key: key})
+		return 0, &newError
+	}
+
+	return ttl, nil
+}
+
+func (r RedisModel) Expire(ctx context.Context, key string, expiration time.Duration) (bool, *error) {
+
+	client, err := r.getClient()
+	if err != nil {
+		return false, err
+	}
+
+	ok, errExpire := client.Expire(ctx, key, expiration).Result()
+	if errExpire != nil {
+		return false, &errExpire
+	}
+
+	return ok, nil
+}
+
+func (r RedisModel) Incr(ctx context.Context, key string) (int64, *error) {
+
+	client, err := r.getClient()
+	if err != nil {
+		return 0, err
+	}
+
+	value, errIncr := client.Incr(ctx, key).Result()
+	if errIncr != nil {
+		return 0, &errIncr
+	}
+
+	return value, nil
 }
 
-func (r RedisModel) Get(key string, redisType string) (any, *error) {
+func (r RedisModel) Decr(ctx context.Context, key string) (int64, *error) {
 
-	client, err := open(r)
+	client, err := r.getClient()
+	if err != nil {
+		return 0, err
+	}
+
+	value, errDecr := client.Decr(ctx, key).Result()
+	if errDecr != nil {
+		return 0, &errDecr
+	}
+
+	return value, nil
+}
+
+// MGet returns the values of the keys that exist; missing keys are left out
+// of the map.
+func (r RedisModel) MGet(ctx context.Context, keys ...string) (map[string]string, *error) {
+
+	client, err := r.getClient()
 	if err != nil {
 		return nil, err
 	}
 
-	value, errGet := client.Get(context.Background(), key).Result()
+	values, errGet := client.MGet(ctx, keys...).Result()
 	if errGet != nil {
 		return nil, &errGet
 	}
 
-	switch redisType {
-	case JsonRedis:
-		var jsonResult interface{}
-		if err := json.Unmarshal([]byte(value), &jsonResult); err != nil {
-			return nil, &err
+	result := make(map[string]string)
+	for i, value := range values {
+		if stringValue, ok := value.(string); ok {
+			result[keys[i]] = stringValue
 		}
-	case XmlType:
-		var xmlResult interface{}
-		if err := xml.Unmarshal([]byte(value), &xmlResult); err != nil {
-			return nil, &err
-		}
-	case SingleType:
-		return value, nil
 	}
 
-	newError := errors.New("invalid redis type")
-	return nil, &newError
+	return result, nil
+}
 
+func (r RedisModel) MSet(ctx context.Context, values map[string]any) *error {
+
+	client, err := r.getClient()
+	if err != nil {
+		return err
+	}
+
+	if err := client.MSet(ctx, values).Err(); err != nil {
+		return &err
+	}
+
+	return nil
 }
 
-func open(model RedisModel) (*redis.Client, *error) {
+func GetJSON[T any](ctx context.Context, r IRedisConfig, key string) (T, *error) {
 
-	client := redis.NewClient(&redis.Options{
-		Addr:     model.Domain + ":" + model.Port,
-		Password: model.Password,
-		DB:       0,
+	var result T
+	value, err := r.Get(ctx, key)
+	if err != nil {
+		return result, err
+	}
+
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		return result, &err
+	}
+
+	return result, nil
+}
+
+func GetXML[T any](ctx context.Context, r IRedisConfig, key string) (T, *error) {
+
+	var result T
+	value, err := r.Get(ctx, key)
+	if err != nil {
+		return result, err
+	}
+
+	if err := xml.Unmarshal([]byte(value), &result); err != nil {
+		return result, &err
+	}
+
+	return result, nil
+}
+
+func IsRedisNotFound(err error) bool {
+	var notFound RedisNotFoundError
+	return errors.As(err, &notFound)
+}
+
+func notFoundError(err error, key string) error {
+	if errors.Is(err, redis.Nil) {
+		return RedisNotFoundError{Key: key}
+	}
+
+	return err
+}
+
+// redisPoolFor returns the pool of a model built by NewRedisConfig. A
+// RedisModel literal shares the pool of every other literal with the same
+// connection settings, created on first use.
+func (r RedisModel) redisPoolFor() *redisPool {
+
+	if r.pool != nil {
+		return r.pool
+	}
+
+	key := fmt.Sprintf("%s:%s|%s|%d|%d|%d|%s|%s|%s", r.Domain, r.Port, r.Password, r.DB,
+		r.PoolSize, r.MinIdleConns, r.DialTimeout, r.ReadTimeout, r.WriteTimeout)
+	pool, _ := redisPools.LoadOrStore(key, &redisPool{})
+
+	return pool.(*redisPool)
+}
+
+// getClient returns the pooled client, creating it on first use. The ping
+// of a new client runs outside the pool lock and is bounded by DialTimeout,
+// so a slow redis does not hold up callers of an already open client.
+func (r RedisModel) getClient() (*redis.Client, *error) {
+
+	pool := r.redisPoolFor()
+
+	pool.mu.Lock()
+	client := pool.client
+	pool.mu.Unlock()
+
+	if client != nil {
+		return client, nil
+	}
+
+	client = redis.NewClient(&redis.Options{
+		Addr:         r.Domain + ":" + r.Port,
+		Password:     r.Password,
+		DB:           r.DB,
+		PoolSize:     r.PoolSize,
+		MinIdleConns: r.MinIdleConns,
+		DialTimeout:  r.DialTimeout,
+		ReadTimeout:  r.ReadTimeout,
+		WriteTimeout: r.WriteTimeout,
 	})
 
-	if _, err := client.Ping(context.Background()).Result(); err != nil {
+	pingTimeout := r.DialTimeout
+	if pingTimeout <= 0 {
+		pingTimeout = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	if _, err := client.Ping(ctx).Result(); err != nil {
+		_ = client.Close()
 		return nil, &err
 	}
 
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.client != nil {
+		_ = client.Close()
+		return pool.client, nil
+	}
+
+	pool.client = client
+
 	return client, nil
 }
@@ -0,0 +1,241 @@
+package infra
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrLockNotAcquired = errors.New("redis lock not acquired")
+	ErrLockNotHeld     = errors.New("redis lock is not held")
+)
+
+// releaseScript and extendScript only touch the key while it still holds
+// the token of the caller, so an expired lock taken over by another
+// instance is never released or extended by mistake.
+var (
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+	extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+type RedisLockModel struct {
+	Key           string
+	TTL           time.Duration
+	AutoExtend    bool
+	RetryInterval time.Duration
+}
+
+type RedisLock struct {
+	redis      IRedisConfig
+	key        string
+	token      string
+	ttl        time.Duration
+	stopExtend context.CancelFunc
+	extendDone chan struct{}
+
+	mu   sync.Mutex
+	held bool
+}
+
+type IRedisLock interface {
+	Acquire(ctx context.Context, model RedisLockModel) (*RedisLock, *error)
+	TryAcquire(ctx context.Context, model RedisLockModel, timeout time.Duration) (*RedisLock, *error)
+}
+
+type redisLockContext struct {
+	redis IRedisConfig
+}
+
+func NewRedisLock(redis IRedisConfig) IRedisLock {
+	return redisLockContext{
+		redis: redis,
+	}
+}
+
+// Acquire makes a single attempt and returns ErrLockNotAcquired when the
+// key is already held.
+func (l redisLockContext) Acquire(ctx context.Context, model RedisLockModel) (*RedisLock, *error) {
+
+	if model.TTL < time.Millisecond {
+		newError := errors.New("redis lock ttl must be at least 1ms")
+		return nil, &newError
+	}
+
+	token := uuid.NewString()
+	ok, err := l.redis.SetNX(ctx, model.Key, token, model.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		newError := ErrLockNotAcquired
+		return nil, &newError
+	}
+
+	lock := &RedisLock{
+		redis: l.redis,
+		key:   model.Key,
+		token: token,
+		ttl:   model.TTL,
+		held:  true,
+	}
+
+	if model.AutoExtend {
+		lock.startExtend()
+	}
+
+	return lock, nil
+}
+
+// TryAcquire retries Acquire every RetryInterval until the lock is taken,
+// timeout elapses or ctx is done.
+func (l redisLockContext) TryAcquire(ctx context.Context, model RedisLockModel, timeout time.Duration) (*RedisLock, *error) {
+
+	retryInterval := model.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = 50 * time.Millisecond
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		lock, err := l.Acquire(ctx, model)
+		if err == nil {
+			return lock, nil
+		}
+
+		if !errors.Is(*err, ErrLockNotAcquired) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			newError := ErrLockNotAcquired
+			return nil, &newError
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (l *RedisLock) Key() string {
+	return l.key
+}
+
+func (l *RedisLock) Token() string {
+	return l.token
+}
+
+// Extend resets the lock expiry to ttl, as long as it is still held by this
+// token.
+func (l *RedisLock) Extend(ctx context.Context, ttl time.Duration) *error {
+
+	if ttl < time.Millisecond {
+		newError := errors.New("redis lock ttl must be at least 1ms")
+		return &newError
+	}
+
+	client, err := l.redis.Client()
+	if err != nil {
+		return err
+	}
+
+	result, errExtend := extendScript.Run(ctx, client, []string{l.key}, l.token, ttl.Milliseconds()).Int64()
+	if errExtend != nil {
+		return &errExtend
+	}
+
+	if result == 0 {
+		l.mu.Lock()
+		l.held = false
+		l.mu.Unlock()
+
+		newError := ErrLockNotHeld
+		return &newError
+	}
+
+	return nil
+}
+
+func (l *RedisLock) Release(ctx context.Context) *error {
+
+	if l.stopExtend != nil {
+		l.stopExtend()
+		<-l.extendDone
+	}
+
+	l.mu.Lock()
+	l.held = false
+	l.mu.Unlock()
+
+	client, err := l.redis.Client()
+	if err != nil {
+		return err
+	}
+
+	result, errRelease := releaseScript.Run(ctx, client, []string{l.key}, l.token).Int64()
+	if errRelease != nil {
+		return &errRelease
+	}
+
+	if result == 0 {
+		newError := ErrLockNotHeld
+		return &newError
+	}
+
+	return nil
+}
+
+// IsHeld reports whether the last acquire, extend or release left the lock
+// with this instance.
+func (l *RedisLock) IsHeld() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.held
+}
+
+func (l *RedisLock) startExtend() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l.stopExtend = cancel
+	l.extendDone = make(chan struct{})
+
+	go func() {
+		defer close(l.extendDone)
+
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := l.Extend(ctx, l.ttl); err != nil {
+					if ZapLog != nil && ctx.Err() == nil {
+						ZapLog.Warn("redis lock extend failed", zap.String("key", l.key),
+							zap.String("error", (*err).Error()))
+					}
+					if errors.Is(*err, ErrLockNotHeld) {
+						return
+					}
+				}
+			}
+		}
+	}()
+}
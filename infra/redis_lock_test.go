@@ -0,0 +1,148 @@
+package infra
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestRedisLock(t *testing.T) (IRedisConfig, IRedisLock) {
+	_, model := newTestRedis(t)
+	redisConfig := NewRedisConfig(model)
+	t.Cleanup(func() { redisConfig.Close() })
+
+	return redisConfig, NewRedisLock(redisConfig)
+}
+
+func TestRedisLockAcquireRelease(t *testing.T) {
+	_, locker := newTestRedisLock(t)
+	ctx := context.Background()
+	model := RedisLockModel{Key: "lock:req-1", TTL: time.Second}
+
+	lock, err := locker.Acquire(ctx, model)
+	if err != nil {
+		t.Fatal(*err)
+	}
+
+	if _, err := locker.Acquire(ctx, model); err == nil || !errors.Is(*err, ErrLockNotAcquired) {
+		t.Fatal("expected the second acquire to fail")
+	}
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(*err)
+	}
+
+	if lock.IsHeld() {
+		t.Fatal("expected the lock to be released")
+	}
+
+	if err := lock.Release(ctx); err == nil || !errors.Is(*err, ErrLockNotHeld) {
+		t.Fatal("expected a second release to report the lock as not held")
+	}
+
+	if _, err := locker.Acquire(ctx, model); err != nil {
+		t.Fatal(*err)
+	}
+}
+
+func TestRedisLockReleaseKeepsForeignToken(t *testing.T) {
+	redisConfig, locker := newTestRedisLock(t)
+	ctx := context.Background()
+
+	lock, err := locker.Acquire(ctx, RedisLockModel{Key: "lock:req-2", TTL: time.Second})
+	if err != nil {
+		t.Fatal(*err)
+	}
+
+	if err := redisConfig.Set(ctx, "lock:req-2", "other-instance", time.Second); err != nil {
+		t.Fatal(*err)
+	}
+
+	if err := lock.Release(ctx); err == nil || !errors.Is(*err, ErrLockNotHeld) {
+		t.Fatal("expected release to leave a lock taken over by another instance")
+	}
+
+	if value, _ := redisConfig.Get(ctx, "lock:req-2"); value != "other-instance" {
+		t.Fatalf("expected the foreign lock to stay, got %q", value)
+	}
+
+	if err := lock.Extend(ctx, time.Second); err == nil || !errors.Is(*err, ErrLockNotHeld) {
+		t.Fatal("expected extend to leave a lock taken over by another instance")
+	}
+}
+
+func TestRedisLockTryAcquire(t *testing.T) {
+	_, locker := newTestRedisLock(t)
+	ctx := context.Background()
+	model := RedisLockModel{Key: "lock:req-3", TTL: time.Second, RetryInterval: 5 * time.Millisecond}
+
+	lock, err := locker.Acquire(ctx, model)
+	if err != nil {
+		t.Fatal(*err)
+	}
+
+	if _, err := locker.TryAcquire(ctx, model, 30*time.Millisecond); err == nil || !errors.Is(*err, ErrLockNotAcquired) {
+		t.Fatal("expected try acquire to time out")
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		lock.Release(ctx)
+	}()
+
+	if _, err := locker.TryAcquire(ctx, model, time.Second); err != nil {
+		t.Fatal(*err)
+	}
+}
+
+func TestRedisLockAutoExtend(t *testing.T) {
+	server, model := newTestRedis(t)
+	redisConfig := NewRedisConfig(model)
+	defer redisConfig.Close()
+
+	ctx := context.Background()
+	lock, err := NewRedisLock(redisConfig).Acquire(ctx, RedisLockModel{
+		Key:        "lock:req-4",
+		TTL:        30 * time.Millisecond,
+		AutoExtend: true,
+	})
+	if err != nil {
+		t.Fatal(*err)
+	}
+
+	server.FastForward(25 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	if ttl := server.TTL("lock:req-4"); ttl != 30*time.Millisecond {
+		t.Fatalf("expected the ttl to be extended to 30ms, got %s", ttl)
+	}
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(*err)
+	}
+
+	if server.Exists("lock:req-4") {
+		t.Fatal("expected release to delete the key")
+	}
+}
+
+func TestRedisLockRejectsShortTTL(t *testing.T) {
+	_, locker := newTestRedisLock(t)
+	ctx := context.Background()
+
+	for _, ttl := range []time.Duration{0, time.Nanosecond, time.Microsecond} {
+		if _, err := locker.Acquire(ctx, RedisLockModel{Key: "lock:req-5", TTL: ttl, AutoExtend: true}); err == nil {
+			t.Fatalf("expected ttl %s to be rejected", ttl)
+		}
+	}
+
+	lock, err := locker.Acquire(ctx, RedisLockModel{Key: "lock:req-5", TTL: time.Millisecond})
+	if err != nil {
+		t.Fatal(*err)
+	}
+
+	if err := lock.Extend(ctx, time.Microsecond); err == nil {
+		t.Fatal("expected extend to reject a ttl under 1ms")
+	}
+}
@@ -0,0 +1,178 @@
+package infra
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(tb testing.TB) (*miniredis.Miniredis, RedisModel) {
+	server := miniredis.RunT(tb)
+
+	model := RedisModel{
+		Domain: server.Host(),
+		Port:   server.Port(),
+	}
+
+	return server, model
+}
+
+func TestRedisModelSharesOneClient(t *testing.T) {
+	_, model := newTestRedis(t)
+	redisConfig := NewRedisConfig(model)
+	defer redisConfig.Close()
+
+	first, err := redisConfig.Client()
+	if err != nil {
+		t.Fatal(*err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			client, err := redisConfig.Client()
+			if err != nil {
+				t.Error(*err)
+				return
+			}
+
+			if client != first {
+				t.Error("expected the pooled client to be reused")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRedisModelLiteral(t *testing.T) {
+	_, model := newTestRedis(t)
+	defer model.Close()
+
+	ctx := context.Background()
+	if err := model.Set(ctx, "key", "value", time.Minute); err != nil {
+		t.Fatal(*err)
+	}
+
+	value, err := model.Get(ctx, "key")
+	if err != nil {
+		t.Fatal(*err)
+	}
+
+	if value != "value" {
+		t.Fatalf("expected value, got %s", value)
+	}
+
+	first, _ := model.Client()
+	second, _ := RedisModel{Domain: model.Domain, Port: model.Port}.Client()
+	if first != second {
+		t.Fatal("expected literals with the same settings to share a client")
+	}
+}
+
+func TestRedisModelHelpers(t *testing.T) {
+	server, model := newTestRedis(t)
+	redisConfig := NewRedisConfig(model)
+	defer redisConfig.Close()
+
+	ctx := context.Background()
+
+	type payload struct {
+		Name string `json:"name" xml:"name"`
+	}
+
+	if err := redisConfig.SetJSON(ctx, "json", payload{Name: "a"}, time.Minute); err != nil {
+		t.Fatal(*err)
+	}
+
+	decoded, err := GetJSON[payload](ctx, redisConfig, "json")
+	if err != nil || decoded.Name != "a" {
+		t.Fatalf("unexpected json value %+v", decoded)
+	}
+
+	if err := redisConfig.SetXML(ctx, "xml", payload{Name: "b"}, time.Minute); err != nil {
+		t.Fatal(*err)
+	}
+
+	decodedXml, err := GetXML[payload](ctx, redisConfig, "xml")
+	if err != nil || decodedXml.Name != "b" {
+		t.Fatalf("unexpected xml value %+v", decodedXml)
+	}
+
+	if _, err := redisConfig.Get(ctx, "missing"); err == nil || !IsRedisNotFound(*err) {
+		t.Fatal("expected a not found error")
+	}
+
+	if _, err := redisConfig.TTL(ctx, "missing"); err == nil || !IsRedisNotFound(*err) {
+		t.Fatal("expected a not found error for the ttl")
+	}
+
+	ok, err := redisConfig.SetNX(ctx, "json", "other", time.Minute)
+	if err != nil || ok {
+		t.Fatal("expected SetNX to keep the existing key")
+	}
+
+	if value, _ := redisConfig.Incr(ctx, "counter"); value != 1 {
+		t.Fatalf("expected 1, got %d", value)
+	}
+
+	values, err := redisConfig.MGet(ctx, "counter", "missing")
+	if err != nil || len(values) != 1 || values["counter"] != "1" {
+		t.Fatalf("unexpected values %v", values)
+	}
+
+	server.FastForward(2 * time.Minute)
+	if exist, _ := redisConfig.Exists(ctx, "json", "xml"); exist != 0 {
+		t.Fatalf("expected the keys to expire, %d left", exist)
+	}
+}
+
+func TestRedisModelOpenFails(t *testing.T) {
+	server, model := newTestRedis(t)
+	server.Close()
+
+	model.DialTimeout = 100 * time.Millisecond
+	if err := NewRedisConfig(model).Open(); err == nil {
+		t.Fatal("expected open to fail on a closed server")
+	}
+}
+
+func BenchmarkRedisSetPooled(b *testing.B) {
+	_, model := newTestRedis(b)
+	redisConfig := NewRedisConfig(model)
+	defer redisConfig.Close()
+
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := redisConfig.Set(ctx, "key", "value", time.Minute); err != nil {
+			b.Fatal(*err)
+		}
+	}
+}
+
+// BenchmarkRedisSetClientPerCall follows the former open() path, a new
+// client and a PING for every operation.
+func BenchmarkRedisSetClientPerCall(b *testing.B) {
+	_, model := newTestRedis(b)
+
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client := redis.NewClient(&redis.Options{Addr: model.Domain + ":" + model.Port})
+		if err := client.Ping(ctx).Err(); err != nil {
+			b.Fatal(err)
+		}
+
+		if err := client.Set(ctx, "key", "value", time.Minute).Err(); err != nil {
+			b.Fatal(err)
+		}
+		_ = client.Close()
+	}
+}